@@ -1,92 +1,241 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
-	"os/exec"
-)
-
-// read in an MP3
-// process it to get some frequency analysis at 30fps.
-// draw a circular spectrum analyser like trap nation.
-// Lets assume we have ffmpeg.
-// ffmpeg a give us a raw pcm stream.
-
-// need a file system to store the file so we can get ffmpeg to load it twice.
-type Config struct {
-	FFMpegPath string
-
-	// audio input config
-	AudioFile string
-
-	// video output config
-	VideoFile            string
-	Width                int
-	Height               int
-	FPS                  int
-	VideoCodecAndOptions []string
-	AudioCodecAndOptions []string
-}
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 
-var (
-	// default video will be 720p30
-	defaultWidth  = 1280
-	defaultHeight = 720
-	defaultFPS    = 30
-	// default codec options
-	defaultVideoOptions = []string{"libx264", "-preset", "ultrafast", "-crf", "0"} // 264 is simple enough
-	defaultAudioOptions = []string{"copy"}                                         // keep whatever the original was
+	"github.com/thechriswalker/visualisation/vis"
 )
 
-var (
-	infile  = flag.String("audio", "", "The path to an audio file for input")
-	outfile = flag.String("video", "output/output.mkv", "The path to a video file for output")
-)
+// commands are the subcommands, each with their own flags.
+// `render` is the default if the first argument is a flag (or missing).
+var commands = map[string]func(ctx context.Context, args []string){
+	"render":   renderCommand,
+	"batch":    batchCommand,
+	"preview":  previewCommand,
+	"analyze":  analyzeCommand,
+	"selftest": selftestCommand,
+}
 
 func main() {
-	flag.Parse()
-
-	ffmpeg, err := exec.LookPath("ffmpeg")
-	if err != nil {
-		log.Fatalln("Can't find ffmpeg in path:", err)
+	name, args := "render", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		names := make([]string, 0, len(commands))
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		log.Fatalf("Unknown command %q, must be one of: %s", name, strings.Join(names, ", "))
 	}
+	// ctrl-c stops the rendering, but still finishes the video
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cmd(ctx, args)
+}
 
-	if *infile == "" {
-		log.Fatal("Must provide an audio input file '-audio'")
+// commandConfig is the config for a command from its parsed flags, the
+// visual and output ones are nil for the commands without them. The
+// commands all read audio, so they stop here without ffmpeg, or with a
+// bad flag. A '-bpm auto' is estimated here too.
+func commandConfig(ctx context.Context, af *vis.AudioFlags, vf *vis.VisualFlags, of *vis.OutputFlags) *vis.Config {
+	config := vis.DefaultConfig()
+	if config.FFMpegPath == "" {
+		log.Fatal(vis.ErrNoFFMpeg)
 	}
-	if *outfile == "" {
-		log.Fatal("Must provide a video output destination '-video'")
+	if err := af.Apply(config); err != nil {
+		log.Fatal(err)
 	}
-	// create config
-	config := &Config{
-		FFMpegPath:           ffmpeg,
-		AudioFile:            *infile,
-		VideoFile:            *outfile,
-		FPS:                  defaultFPS,
-		Width:                defaultWidth,
-		Height:               defaultHeight,
-		VideoCodecAndOptions: defaultVideoOptions,
-		AudioCodecAndOptions: defaultAudioOptions,
+	if vf != nil {
+		if err := vf.Apply(config); err != nil {
+			log.Fatal(err)
+		}
 	}
+	if of != nil {
+		if err := of.Apply(config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := vis.FindTempo(ctx, config); err != nil {
+		log.Fatal(err)
+	}
+	return config
+}
 
-	audio, err := NewAudioSource(config)
-	if err != nil {
-		panic(err)
+// newFlagSet creates the flags for a command, with the -profile flag they all have
+func newFlagSet(name string) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	profile := fs.Bool("profile", false, "Time each stage of the pipeline and print a breakdown at the end")
+	return fs, profile
+}
+
+// renderCommand renders the visualisation to a video file
+func renderCommand(ctx context.Context, args []string) {
+	fs, profile := newFlagSet("render")
+	af, vf, of := vis.AddAudioFlags(fs), vis.AddVisualFlags(fs), vis.AddOutputFlags(fs)
+	fs.Parse(args)
+
+	config := commandConfig(ctx, af, vf, of)
+
+	var prof *vis.Profile
+	if *profile {
+		prof = vis.NewProfile()
+		defer prof.Print(os.Stderr)
 	}
 
-	video, err := NewVideoSink(config)
+	v, err := vis.NewVisualisation(config)
 	if err != nil {
 		panic(err)
 	}
+	if err := vis.RenderVideo(ctx, config, v, prof); err == vis.ErrNoFrames {
+		// the output is empty (or broken) so make sure scripts notice.
+		log.Fatal(err)
+	} else if err == context.Canceled {
+		log.Fatal("Interrupted")
+	} else if err != nil {
+		panic(err)
+	}
+}
 
-	vis := NewVisualisation(config)
+// batchCommand renders every audio file in a directory, carrying on
+// past the ones that fail.
+func batchCommand(ctx context.Context, args []string) {
+	fs, profile := newFlagSet("batch")
+	inputDir := fs.String("input-dir", "", "The directory of audio files to render")
+	outputDir := fs.String("output-dir", "", "The directory to write the videos to, named after the audio files")
+	glob := fs.String("glob", "*", "Only render the files in '-input-dir' matching this pattern, e.g. '*.mp3'")
+	ext := fs.String("ext", ".mkv", "The extension (so the container) of the videos")
+	af, vf, of := vis.AddAudioFlags(fs), vis.AddVisualFlags(fs), vis.AddOutputFlags(fs)
+	fs.Parse(args)
 
-	err = audio.StartProcessing(func(f *AudioFrame) error {
-		img := vis.CreateFrame(f)
-		return video.SendFrame(img)
+	if *inputDir == "" || *outputDir == "" {
+		log.Fatal("Must provide the input and output directories '-input-dir', '-output-dir'")
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "audio", "audio2", "video", "dump-data", "poster", "spectrogram-image":
+			log.Fatalf("The inputs and outputs come from the directories in batch mode '-%s'", f.Name)
+		}
 	})
+	matches, err := filepath.Glob(filepath.Join(*inputDir, *glob))
 	if err != nil {
+		log.Fatalf("Bad pattern '-glob %s': %s", *glob, err)
+	}
+	var files []string
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+			files = append(files, m)
+		}
+	}
+	if len(files) == 0 {
+		log.Fatalf("No audio files found in '%s'", *inputDir)
+	}
+	if err := os.MkdirAll(*outputDir, 0o755); err != nil {
+		log.Fatalln("Can't create the output directory:", err)
+	}
+
+	var prof *vis.Profile
+	if *profile {
+		prof = vis.NewProfile()
+		defer prof.Print(os.Stderr)
+	}
+
+	var v *vis.Visualisation
+	failed := 0
+	for i, file := range files {
+		base := filepath.Base(file)
+		out := filepath.Join(*outputDir, strings.TrimSuffix(base, filepath.Ext(base))+*ext)
+		log.Printf("[%d/%d] %s -> %s", i+1, len(files), file, out)
+		if vis.SamePath(file, out) {
+			log.Printf("[%d/%d] failed: it would overwrite the audio", i+1, len(files))
+			failed++
+			continue
+		}
+		// each file gets a fresh config, the same as running render for it
+		fs.Set("audio", file)
+		fs.Set("video", out)
+		config := commandConfig(ctx, af, vf, of)
+		if v == nil {
+			if v, err = vis.NewVisualisation(config); err != nil {
+				log.Fatal(err)
+			}
+		}
+		// the tempo and sample rate can be different for each file
+		v.SetTrack(config)
+		if err := vis.RenderVideo(ctx, config, v, prof); err == context.Canceled {
+			log.Fatalf("[%d/%d] interrupted", i+1, len(files))
+		} else if err != nil {
+			log.Printf("[%d/%d] failed: %s", i+1, len(files), err)
+			failed++
+			continue
+		}
+		log.Printf("[%d/%d] done", i+1, len(files))
+	}
+	log.Printf("Rendered %d of %d files", len(files)-failed, len(files))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// previewCommand plays the visualisation with ffplay instead of saving it
+func previewCommand(ctx context.Context, args []string) {
+	fs, profile := newFlagSet("preview")
+	af, vf := vis.AddAudioFlags(fs), vis.AddVisualFlags(fs)
+	fs.Parse(args)
+
+	config := commandConfig(ctx, af, vf, nil)
+	// ffplay shows the problems, we don't want the encoder log as well
+	config.Quiet = true
+
+	var prof *vis.Profile
+	if *profile {
+		prof = vis.NewProfile()
+		defer prof.Print(os.Stderr)
+	}
+
+	if err := vis.Preview(ctx, config, prof); err == vis.ErrNoFFPlay {
+		log.Fatal(err)
+	} else if err != nil {
 		panic(err)
 	}
+}
 
+// analyzeCommand only analyses the audio and prints statistics
+func analyzeCommand(ctx context.Context, args []string) {
+	fs, profile := newFlagSet("analyze")
+	af := vis.AddAudioFlags(fs)
+	peaks := fs.Int("peaks", 3, "The number of dominant frequencies to list for each '-peak-window', 0 for none")
+	peakWindow := fs.Float64("peak-window", 10, "The length in seconds of each stretch of the audio '-peaks' lists the frequencies of")
+	fs.Parse(args)
+
+	if *peaks < 0 {
+		log.Fatal("Peaks must not be negative '-peaks'")
+	}
+	if *peakWindow <= 0 {
+		log.Fatal("Peak window must be more than 0 seconds '-peak-window'")
+	}
+	config := commandConfig(ctx, af, nil, nil)
+
+	var prof *vis.Profile
+	if *profile {
+		prof = vis.NewProfile()
+		defer prof.Print(os.Stderr)
+	}
+
+	stats, err := vis.Analyze(ctx, config, *peaks, *peakWindow, prof)
+	if err == context.Canceled {
+		log.Println("Interrupted, the statistics are only for the audio so far")
+	} else if err != nil {
+		panic(err)
+	}
+	stats.Print(os.Stdout)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/thechriswalker/visualisation/vis"
+)
+
+// selftestCommand renders a known pattern, a sine wave sweeping from the
+// bass to the treble, through the whole pipeline. So it needs no audio file
+// and shows if ffmpeg (and the codecs) work on a new machine.
+func selftestCommand(ctx context.Context, args []string) {
+	fs, profile := newFlagSet("selftest")
+	duration := fs.Float64("duration", 5, "The length in seconds of the test video")
+	af, vf, of := vis.AddAudioFlags(fs), vis.AddVisualFlags(fs), vis.AddOutputFlags(fs)
+	// not over a real render's default
+	video := fs.Lookup("video")
+	video.DefValue = "selftest.mkv"
+	video.Value.Set(video.DefValue)
+	fs.Parse(args)
+
+	if *duration <= 0 {
+		log.Fatal("Duration must be more than 0 '-duration'")
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "audio", "audio2":
+			log.Fatalf("The self test makes its own audio '-%s'", f.Name)
+		}
+	})
+	dir, err := os.MkdirTemp("", "selftest")
+	if err != nil {
+		log.Fatalln("Can't create a temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	sweep := filepath.Join(dir, "sweep.wav")
+	if err := vis.WriteSweep(sweep, *duration); err != nil {
+		log.Fatalln("Can't write the test audio:", err)
+	}
+	fs.Set("audio", sweep)
+
+	config := commandConfig(ctx, af, vf, of)
+
+	var prof *vis.Profile
+	if *profile {
+		prof = vis.NewProfile()
+		defer prof.Print(os.Stderr)
+	}
+
+	v, err := vis.NewVisualisation(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := vis.RenderVideo(ctx, config, v, prof); err != nil {
+		os.RemoveAll(dir)
+		log.Fatalln("Self test failed:", err)
+	}
+	if config.VideoFile != "" && config.VideoFile != vis.StdoutFile && config.FFProbePath != "" {
+		// -shortest should have ended them together
+		video, audio, err := vis.StreamDurations(config.FFProbePath, config.VideoFile)
+		if err != nil {
+			os.RemoveAll(dir)
+			log.Fatalln("Self test failed, can't probe the video:", err)
+		}
+		tolerance := 2 / vis.OutputFrameRate(config)
+		if video > 0 && audio > 0 && math.Abs(video-audio) > tolerance {
+			os.RemoveAll(dir)
+			log.Fatalf("Self test failed, the video is %.3fs long but the audio is %.3fs", video, audio)
+		}
+	}
+	log.Println("Self test passed, check the peak sweeps smoothly round the circle:", config.VideoFile)
+}
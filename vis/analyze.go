@@ -0,0 +1,172 @@
+package vis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// AnalysisStats collects summary statistics from the audio frames, so
+// the scale/exponent/normalisation can be tuned without rendering.
+type AnalysisStats struct {
+	fps     float64
+	frames  int
+	peak    float64 // the largest magnitude seen
+	peakAt  int     // the frame the peak was in
+	decades []decade
+	tempo   *TempoEstimator
+	// the dominant frequencies of each window of the audio
+	topK         int
+	windowFrames int
+	window       []float64 // the magnitudes summed over the current window
+	windowFrom   int       // the frame the current window started at
+	binHz        float64   // how far apart the FFT bins are
+	windows      []peakWindow
+}
+
+// peakWindow is the dominant frequencies over a stretch of the audio
+type peakWindow struct {
+	from  int // frame
+	peaks []peakBin
+}
+
+// peakBin is a peak in the spectrum
+type peakBin struct {
+	hz, magnitude float64
+}
+
+// decade is a band of frequencies, a factor of 10 wide (except the last)
+type decade struct {
+	from, to float64 // Hz
+	sum      float64
+	count    int
+}
+
+// Analyze reads through the audio in the config (only its spectrum) for
+// the statistics. If the context is cancelled they are for the audio so
+// far, with its error.
+func Analyze(ctx context.Context, c *Config, topK int, window float64, prof *Profile) (*AnalysisStats, error) {
+	ac := *c
+	ac.Style = styleSpectrum
+	process, err := openAudio(ctx, &ac, prof)
+	if err != nil {
+		return nil, err
+	}
+	stats := NewAnalysisStats(&ac, topK, window)
+	return stats, process(ctx, stats.Add)
+}
+
+// NewAnalysisStats creates the stats collector for the config, listing the
+// topK frequencies of every window seconds of the audio.
+func NewAnalysisStats(c *Config, topK int, window float64) *AnalysisStats {
+	nyquist := float64(c.SampleRate) / 2
+	as := &AnalysisStats{
+		fps:          frameRate(c),
+		tempo:        NewTempoEstimator(frameRate(c)),
+		topK:         topK,
+		windowFrames: int(math.Max(1, math.Round(window*frameRate(c)))),
+	}
+	for f := 10.0; f < nyquist; f *= 10 {
+		as.decades = append(as.decades, decade{from: f, to: math.Min(f*10, nyquist)})
+	}
+	return as
+}
+
+// Add is the onFrame callback for AudioSource.StartProcessing
+func (as *AnalysisStats) Add(af *AudioFrame) error {
+	// only the first half of the FFT is useful, the rest is the mirror image.
+	n := len(af.freq)
+	if as.window == nil {
+		as.window = make([]float64, n/2)
+		as.binHz = float64(af.SampleRate()) / float64(n)
+	}
+	for i := 0; i < n/2; i++ {
+		m := af.freq[i]
+		as.window[i] += m
+		if m > as.peak {
+			as.peak = m
+			as.peakAt = as.frames
+		}
+		hz := float64(i) * float64(af.SampleRate()) / float64(n)
+		for d := range as.decades {
+			if hz >= as.decades[d].from && hz < as.decades[d].to {
+				as.decades[d].sum += m
+				as.decades[d].count++
+				break
+			}
+		}
+	}
+	as.frames++
+	if as.frames-as.windowFrom == as.windowFrames {
+		as.endWindow()
+	}
+	return as.tempo.Add(af)
+}
+
+// endWindow finds the peaks of the window so far and starts the next
+func (as *AnalysisStats) endWindow() {
+	if as.topK == 0 || as.frames == as.windowFrom {
+		return
+	}
+	// the local maxima, so a wide peak isn't listed as its neighbours too
+	// (the DC bin isn't a frequency anyone hears)
+	var peaks []peakBin
+	for i := 1; i < len(as.window); i++ {
+		m := as.window[i]
+		if m <= as.window[i-1] || (i+1 < len(as.window) && m < as.window[i+1]) {
+			continue
+		}
+		peaks = append(peaks, peakBin{
+			hz:        float64(i) * as.binHz,
+			magnitude: m / float64(as.frames-as.windowFrom),
+		})
+	}
+	sort.Slice(peaks, func(i, j int) bool { return peaks[i].magnitude > peaks[j].magnitude })
+	if len(peaks) > as.topK {
+		peaks = peaks[:as.topK]
+	}
+	as.windows = append(as.windows, peakWindow{from: as.windowFrom, peaks: peaks})
+	for i := range as.window {
+		as.window[i] = 0
+	}
+	as.windowFrom = as.frames
+}
+
+// Print writes the summary
+func (as *AnalysisStats) Print(w io.Writer) {
+	duration := time.Duration(float64(as.frames) / as.fps * float64(time.Second))
+	fmt.Fprintf(w, "frames:         %d (%s at %.4gfps)\n", as.frames, duration, as.fps)
+	peakAt := time.Duration(float64(as.peakAt) / as.fps * float64(time.Second))
+	fmt.Fprintf(w, "peak magnitude: %.3f (at %s)\n", as.peak, peakAt)
+	if bpm, offset := as.tempo.Estimate(); bpm > 0 {
+		fmt.Fprintf(w, "tempo:          ~%.1f BPM (first beat at %.2fs, for '-bpm' and '-beat-offset')\n", bpm, offset)
+	} else {
+		fmt.Fprintln(w, "tempo:          no clear beat")
+	}
+	fmt.Fprintln(w, "average magnitude:")
+	for _, d := range as.decades {
+		var avg float64
+		if d.count > 0 {
+			avg = d.sum / float64(d.count)
+		}
+		fmt.Fprintf(w, "  %6.0fHz - %6.0fHz: %.3f\n", d.from, d.to, avg)
+	}
+	// whatever is left is a shorter window
+	as.endWindow()
+	if len(as.windows) == 0 {
+		return
+	}
+	window := time.Duration(float64(as.windowFrames) / as.fps * float64(time.Second))
+	fmt.Fprintf(w, "dominant frequencies (every %s, the bins are %.1fHz apart):\n", window, as.binHz)
+	for _, pw := range as.windows {
+		from := time.Duration(float64(pw.from) / as.fps * float64(time.Second)).Round(time.Second / 10)
+		fmt.Fprintf(w, "  %10s:", from)
+		for _, p := range pw.peaks {
+			fmt.Fprintf(w, " %6.0fHz (%.3f)", p.hz, p.magnitude)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,520 @@
+package vis
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+)
+
+// AudioSource generates the samples we will use to create our visualisation
+// again we will leverage ffmpeg to create the samples from the source codec
+// We will attach a function to be called on every new sample that comes in
+type AudioSource struct {
+	Cmd            *exec.Cmd   // ffmpeg -i <audio> -c:a raw -o -
+	sampleRate     int         // what ffmpeg resamples the audio to, see Config.SampleRate
+	fps, fpsDen    int         // the frame rate is fps/fpsDen, see Config.FPSDen
+	frame          int64       // the frames read so far (from the start of the audio)
+	windowSize     int         // the number of samples analysed each frame, a power of 2 for a fast FFT
+	ring           []float64   // the last windowSize samples
+	ringPos        int         // where the next sample goes in the ring (so also the oldest)
+	channels       [][]float64 // the left and right rings for a stereo source, nil for mono (ring is their mix)
+	timeDomain     bool        // hand over the raw waveform (in data)
+	freqDomain     bool        // run the FFT (into freq)
+	stdout         io.ReadCloser
+	buf            []byte // the raw bytes for a single frame
+	format         sampleFormat
+	profile        *Profile // optional timing
+	windowFunction func(i, s int) float64
+	gain           float64 // the magnitude reference, see Config.MagnitudeGain
+
+	// Transform is the FFT given to each frame, change it before
+	// calling NewFrame/StartProcessing to use a different one.
+	Transform Transformer
+	stderr    *TailBuffer
+}
+
+// NewAudioSource creates and reads the audio source, ffmpeg is killed
+// if the context is cancelled.
+func NewAudioSource(ctx context.Context, c *Config) (*AudioSource, error) {
+	// create the command and start it, but don't read from the stdout yet.
+	// not until we attach the listener
+	// should we do the spectrum analysis here? or raw samples.
+	// we need to support time-based analysis or just frequency
+	// based. I only want frequency, but maybe the "Sample"
+	// type should actually be a type with methods for "TimeDomainAnalysis"
+	// and "FrequencyDomainAnalysis" and we just call whichever one...
+	// that way I can implement the FrequencyDomainAnalysis first.
+	// but first.
+
+	if c.FFMpegPath == "" {
+		return nil, ErrNoFFMpeg
+	}
+	format, ok := sampleFormats[c.SampleFormat]
+	if !ok {
+		return nil, fmt.Errorf("unknown sample format: %q", c.SampleFormat)
+	}
+
+	// we can
+	args := append([]string{}, c.InputOptions...)
+	args = append(args,
+		"-i", c.AudioFile, //our audio file
+		"-vn", // no video
+	)
+	audioFrame, _ := resumePoint(c)
+	if audioFrame > 0 {
+		// after the -i so it is exact to the sample
+		args = append(args, "-ss", strconv.FormatFloat(float64(audioFrame)/frameRate(c), 'f', -1, 64))
+	}
+	if c.LoudNorm {
+		// normalise the loudness so quiet and loud tracks look the same.
+		// NB loudnorm looks ahead a little, so there is a small latency
+		// before the first samples arrive, but the output stays in sync.
+		args = append(args, "-af", fmt.Sprintf("loudnorm=I=%g", c.LoudNormTarget))
+	}
+	channels := 1 //mono
+	if usesLayout(c, layoutStereoSplit) {
+		// a mono file is just the same in both
+		channels = 2
+	}
+	args = append(args,
+		"-ar", strconv.Itoa(c.SampleRate), // nothing to do if it is the native rate
+		"-ac", strconv.Itoa(channels),
+		"-f", c.SampleFormat, // raw output, f64be by default
+		"-c:a", format.codec, // the PCM codec for that format, decoded by sampleFormats
+		"-", // output to stdout
+	)
+	cmd := exec.CommandContext(ctx, c.FFMpegPath, args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	as, err := newAudioSource(c, stdout)
+	if err != nil {
+		return nil, err
+	}
+	as.Cmd = cmd
+	// keep the end of the log in case decoding fails
+	as.stderr = NewTailBuffer(stderrTailSize)
+	cmd.Stderr = as.stderr
+
+	return as, cmd.Start()
+}
+
+// newAudioSource reads the raw samples in the config's format from the
+// reader, it is everything but ffmpeg. So the tests can give it samples.
+func newAudioSource(c *Config, stdout io.ReadCloser) (*AudioSource, error) {
+	format, ok := sampleFormats[c.SampleFormat]
+	if !ok {
+		return nil, fmt.Errorf("unknown sample format: %q", c.SampleFormat)
+	}
+	window := c.WindowFunction
+	if window == nil {
+		makeWindow, ok := windowFunctions[c.Window]
+		if !ok {
+			return nil, fmt.Errorf("unknown window function: %q", c.Window)
+		}
+		window = makeWindow(c.WindowParams)
+	}
+
+	windowSize := c.WindowSize
+	if windowSize == 0 {
+		// the most samples a frame moves on
+		windowSize = nextPowerOf2((c.SampleRate*c.FPSDen + c.FPS - 1) / c.FPS)
+	}
+
+	audioFrame, _ := resumePoint(c)
+	as := &AudioSource{
+		sampleRate:     c.SampleRate,
+		fps:            c.FPS,
+		fpsDen:         c.FPSDen,
+		frame:          int64(audioFrame),
+		windowSize:     windowSize,
+		ring:           make([]float64, windowSize),
+		timeDomain:     usesStyle(c, styleWaveform),
+		freqDomain:     usesStyle(c, styleSpectrum) || usesStyle(c, styleSpectrogram),
+		format:         format,
+		stdout:         stdout,
+		Transform:      goDSPTransformer{},
+		windowFunction: window,
+		gain:           c.MagnitudeGain,
+	}
+	if usesLayout(c, layoutStereoSplit) {
+		as.channels = [][]float64{make([]float64, windowSize), make([]float64, windowSize)}
+	}
+	return as, nil
+}
+
+const (
+	samplingRate   = 44_100 // 44.1khz sampling, unless we use the native rate
+	stderrTailSize = 4096   // how much of ffmpeg's log to keep for errors
+)
+
+// sampleFormat is a raw format we can ask ffmpeg to output,
+// and how to turn each sample back into a float64 from -1 to 1.
+type sampleFormat struct {
+	codec  string
+	size   int // bytes per sample
+	decode func(b []byte) float64
+}
+
+// f64be has the best precision, the others are less data down the pipe.
+var sampleFormats = map[string]sampleFormat{
+	"f64be": {codec: "pcm_f64be", size: 8, decode: func(b []byte) float64 {
+		// read the data as a uint64, and then convert to a float64
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}},
+	"f32le": {codec: "pcm_f32le", size: 4, decode: func(b []byte) float64 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}},
+	"s24le": {codec: "pcm_s24le", size: 3, decode: func(b []byte) float64 {
+		// shift up to the top of an int32 and back down to get the sign
+		v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+		return float64(v) / (1 << 23)
+	}},
+	"s16le": {codec: "pcm_s16le", size: 2, decode: func(b []byte) float64 {
+		return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+	}},
+}
+
+// NewFrame allocates an AudioFrame the right size for this source
+func (as *AudioSource) NewFrame() *AudioFrame {
+	af := &AudioFrame{
+		data:           make([]float64, as.windowSize),
+		freq:           make([]float64, as.windowSize),
+		windowFunction: as.windowFunction,
+		transform:      as.Transform,
+		gain:           as.gain,
+		sampleRate:     as.sampleRate,
+	}
+	for range as.channels {
+		ch := *af
+		ch.data = make([]float64, as.windowSize)
+		ch.freq = make([]float64, as.windowSize)
+		ch.stereo = nil
+		af.stereo = append(af.stereo, &ch)
+	}
+	return af
+}
+
+// samplesAt is how many samples the audio is into the start of frame k.
+// Rounding down each time, rather than reading the same number every
+// frame, means a rate that doesn't divide exactly never drifts: each frame
+// is a sample longer every so often to catch up.
+func (as *AudioSource) samplesAt(k int64) int64 {
+	return k * int64(as.sampleRate) * int64(as.fpsDen) / int64(as.fps)
+}
+
+// ReadFrame reads the next frame's worth of samples and analyses the
+// last `windowSize` samples into the frame. So the analysis window is
+// decoupled from the frame rate: at 30fps we move on 1470 samples each
+// frame but analyse 2048 (overlapping the previous frame a little).
+// It returns io.EOF once there is no more audio.
+func (as *AudioSource) ReadFrame(frame *AudioFrame) error {
+	// we output float64s by default, so I hope they are smooth enough!
+	// a buffer needs to be samplesetsize * bytes per sample (8 for f64)
+	// one channels worth, or the left and right interleaved for stereo
+	size := as.format.size
+	if as.channels != nil {
+		size *= 2
+	}
+	n := int(as.samplesAt(as.frame+1) - as.samplesAt(as.frame))
+	if len(as.buf) < n*size {
+		as.buf = make([]byte, n*size)
+	}
+	done := as.profile.Start("decode")
+	_, err := io.ReadFull(as.stdout, as.buf[:n*size])
+	done()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// we are done! (a partial frame at the end is dropped)
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("reading audio from ffmpeg: %w", err)
+	}
+	// fill the frame
+	// add the new samples to the ring, overwriting the oldest
+	for i := 0; i < n; i++ {
+		b := as.buf[i*size : i*size+size]
+		if as.channels == nil {
+			as.ring[as.ringPos] = as.format.decode(b)
+		} else {
+			l := as.format.decode(b)
+			r := as.format.decode(b[as.format.size:])
+			as.channels[0][as.ringPos] = l
+			as.channels[1][as.ringPos] = r
+			as.ring[as.ringPos] = (l + r) / 2
+		}
+		as.ringPos = (as.ringPos + 1) % as.windowSize
+	}
+	as.frame++
+	// now process the frame.
+	defer as.profile.Start("analysis")()
+	as.analyse(frame, as.ring)
+	for i, ring := range as.channels {
+		as.analyse(frame.stereo[i], ring)
+	}
+	return nil
+}
+
+// analyse fills the frame from the ring and runs the analyses on it
+func (as *AudioSource) analyse(frame *AudioFrame, ring []float64) {
+	as.fillFrame(frame, ring)
+	// before the window function changes them
+	frame.rms = rms(frame.data)
+	if as.freqDomain {
+		frame.runFrequencyAnalysis()
+	}
+	if as.timeDomain {
+		if as.freqDomain {
+			// the window function has changed the samples
+			as.fillFrame(frame, ring)
+		}
+		frame.runTimeDomainAnalysis()
+	}
+}
+
+// fillFrame fills the frame's samples from the ring, oldest to newest
+func (as *AudioSource) fillFrame(frame *AudioFrame, ring []float64) {
+	n := copy(frame.data, ring[as.ringPos:])
+	copy(frame.data[n:], ring[:as.ringPos])
+}
+
+// usesStyle is true if the config (or any of its layers) draws the style
+func usesStyle(c *Config, style string) bool {
+	return anyLayer(c, func(c *Config) bool { return c.Style == style })
+}
+
+// usesLayout is true if the config (or any of its layers) has the layout
+func usesLayout(c *Config, layout string) bool {
+	return anyLayer(c, func(c *Config) bool { return c.Layout == layout })
+}
+
+// anyLayer is true if f is true for the config or any of its layers
+func anyLayer(c *Config, f func(c *Config) bool) bool {
+	if f(c) {
+		return true
+	}
+	for i := range c.Layers {
+		if anyLayer(&c.Layers[i], f) {
+			return true
+		}
+	}
+	return false
+}
+
+// StartProcessing the audio, until it runs out or the context is
+// cancelled (which returns the context's error).
+func (as *AudioSource) StartProcessing(ctx context.Context, onFrame func(ss *AudioFrame) error) error {
+	// start command, read stdout
+	// We read a frame's worth of samples at a time for the frame.
+	// now we read,
+	// turn into float64s
+	// push out the samples.
+	frame := as.NewFrame()
+
+	for {
+		if ctx.Err() != nil {
+			// we don't want the rest
+			as.Stop()
+			return ctx.Err()
+		}
+		if err := as.ReadFrame(frame); err == io.EOF {
+			// we are done! but did ffmpeg finish, fail or get killed?
+			err := as.Wait()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		} else if err != nil {
+			as.Stop()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		// NB we will reuse this frame next time, so
+		// it doesn't belong to the onFrame func and
+		// should not be considered safe after that function returns
+		if err := onFrame(frame); err != nil {
+			as.Stop()
+			return err
+		}
+	}
+}
+
+// Wait for ffmpeg to exit once the audio has all been read.
+// If it failed the error includes the end of its log.
+func (as *AudioSource) Wait() error {
+	if err := as.Cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg audio decode failed: %w\n%s", err, as.stderr)
+	}
+	return nil
+}
+
+// Stop kills ffmpeg, for when we don't want the rest of the audio.
+func (as *AudioSource) Stop() {
+	as.Cmd.Process.Kill()
+	// this will report the kill, which we expect.
+	as.Cmd.Wait()
+}
+
+// WindowParams are the parameters for the window functions that take them
+type WindowParams struct {
+	KaiserBeta float64 // the main-lobe/side-lobe tradeoff, larger is wider but cleaner
+}
+
+// windowFunctions make the window function from the parameters.
+// the first 3 are the most common and have no parameters.
+var windowFunctions = map[string]func(p WindowParams) func(i, s int) float64{
+	"rectangle": func(WindowParams) func(i, s int) float64 {
+		return func(i, s int) float64 {
+			return 1
+		}
+	},
+	"hamming": func(WindowParams) func(i, s int) float64 {
+		return func(i, s int) float64 {
+			return 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(s-1))
+		}
+	},
+	"hann": func(WindowParams) func(i, s int) float64 {
+		return func(i, s int) float64 {
+			return 0.5 * (1 - math.Cos(2*math.Pi*float64(i)/float64(s-1)))
+		}
+	},
+	"kaiser": func(p WindowParams) func(i, s int) float64 {
+		// the denominator is the same for every sample
+		d := besselI0(p.KaiserBeta)
+		return func(i, s int) float64 {
+			r := 2*float64(i)/float64(s-1) - 1
+			return besselI0(p.KaiserBeta*math.Sqrt(1-r*r)) / d
+		}
+	},
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind,
+// by its power series: the sum of ((x/2)^k / k!)^2
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > sum*1e-12; k++ {
+		t := x / 2 / float64(k)
+		term *= t * t
+		sum += term
+	}
+	return sum
+}
+
+// AudioFrame is a group of samples that represent the music at that slice of time
+type AudioFrame struct {
+	data           []float64
+	freq           []float64
+	windowFunction func(i, s int) float64
+	transform      Transformer
+	gain           float64 // multiplies the normalised magnitudes
+	centroid       float64 // the spectral centroid in Hz, 0 for silence (or the waveform)
+	rms            float64 // the root mean square of the samples, 0 to 1
+	sampleRate     int
+	stereo         []*AudioFrame // the left and right channels, nil for mono
+	stems          []*AudioFrame // the stems at the same moment, see Stems, nil for none
+}
+
+// Channel is the left (0) or right (1) channel of a stereo frame.
+// A mono frame is both.
+func (af *AudioFrame) Channel(i int) *AudioFrame {
+	if af.stereo == nil {
+		return af
+	}
+	return af.stereo[i]
+}
+
+// Stem is the frame of the i'th stem, see Stems. Without stems it is
+// the whole mix.
+func (af *AudioFrame) Stem(i int) *AudioFrame {
+	if af.stems == nil {
+		return af
+	}
+	return af.stems[i]
+}
+
+// SampleRate is the rate of the samples in the frame, the FFT bins
+// are SampleRate/len(freq) Hz apart.
+func (af *AudioFrame) SampleRate() int {
+	return af.sampleRate
+}
+
+// RMS is the root mean square of the samples in the frame (before the
+// window function), a simple measure of how loud it is. A full scale
+// sine wave is 1/√2.
+func (af *AudioFrame) RMS() float64 {
+	return af.rms
+}
+
+// Centroid is the spectral centroid of the frame in Hz: the magnitude
+// weighted mean frequency, so higher for brighter sounds.
+func (af *AudioFrame) Centroid() float64 {
+	return af.centroid
+}
+
+// waveformScale brings the raw samples (-1 to 1) into roughly the same
+// range as the frequency magnitudes so the same height multipliers work.
+const waveformScale = 10
+
+// the time domain "analysis". There is nothing to transform, we just
+// normalise the samples so they draw at a similar size to a spectrum.
+// ONLY CALL THIS ONCE PER DATA
+func (af *AudioFrame) runTimeDomainAnalysis() {
+	for i := range af.data {
+		af.data[i] *= waveformScale
+	}
+}
+
+// the frequency analysis transform
+// ONLY CALL THIS ONCE PER DATA
+func (af *AudioFrame) runFrequencyAnalysis() {
+	// convert the data to freqpoints
+	// first step is the window function.
+	s := len(af.data)
+	for i := 0; i < s; i++ {
+		af.data[i] = af.data[i] * af.windowFunction(i, s)
+	}
+	// the AudioSource gives us a power of 2 samples (by default)
+	// so this is the fast version of the FFT.
+	ft := af.transform.Transform(af.data)
+	// and now convert the fft data into the volumes at grequency band,
+	// dividing by the size so the window size doesn't change the height.
+	for i := 0; i < s; i++ {
+		af.freq[i] = math.Sqrt(real(ft[i])*real(ft[i])+imag(ft[i])*imag(ft[i])) * af.gain / float64(s)
+	}
+	// the centroid only uses the first half, the rest is the mirror image
+	var sum, weighted float64
+	for i := 0; i < s/2; i++ {
+		sum += af.freq[i]
+		weighted += af.freq[i] * float64(i)
+	}
+	af.centroid = 0
+	if sum > 0 {
+		af.centroid = weighted / sum * float64(af.sampleRate) / float64(s)
+	}
+}
+
+// rms is the root mean square of the samples, how loud they are
+func rms(data []float64) float64 {
+	var sum float64
+	for _, x := range data {
+		sum += x * x
+	}
+	return math.Sqrt(sum / float64(len(data)))
+}
+
+// nextPowerOf2 is the smallest power of 2 >= n
+func nextPowerOf2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
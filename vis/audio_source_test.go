@@ -0,0 +1,130 @@
+package vis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+)
+
+// testAudioSource reads the samples as ffmpeg would give them, in f64be
+func testAudioSource(t *testing.T, c *Config, samples []float64) *AudioSource {
+	t.Helper()
+	b := make([]byte, 8*len(samples))
+	for i, s := range samples {
+		binary.BigEndian.PutUint64(b[8*i:], math.Float64bits(s))
+	}
+	as, err := newAudioSource(c, io.NopCloser(bytes.NewReader(b)))
+	if err != nil {
+		t.Fatalf("newAudioSource: %v", err)
+	}
+	return as
+}
+
+// sine is seconds of a sine wave at hz, of the amplitude
+func sine(c *Config, hz, amplitude, seconds float64) []float64 {
+	samples := make([]float64, int(seconds*float64(c.SampleRate)))
+	for i := range samples {
+		samples[i] = amplitude * math.Sin(2*math.Pi*hz*float64(i)/float64(c.SampleRate))
+	}
+	return samples
+}
+
+// lastFrame reads all the samples, returning the last frame
+func lastFrame(t *testing.T, c *Config, samples []float64) *AudioFrame {
+	t.Helper()
+	as := testAudioSource(t, c, samples)
+	af := as.NewFrame()
+	frames := 0
+	for as.ReadFrame(af) == nil {
+		frames++
+	}
+	if frames == 0 {
+		t.Fatal("no frames read")
+	}
+	return af
+}
+
+func TestCentroid(t *testing.T) {
+	// hann leaks the least into the far bins
+	c := testConfig(t, "-window", "hann")
+	low := lastFrame(t, c, sine(c, 200, 0.5, 0.5)).Centroid()
+	high := lastFrame(t, c, sine(c, 4000, 0.5, 0.5)).Centroid()
+	// a pure tone is all at its frequency, give or take the leakage
+	if math.Abs(low-200)/200 > 0.05 {
+		t.Errorf("the centroid of a 200Hz tone is %gHz", low)
+	}
+	if math.Abs(high-4000)/4000 > 0.05 {
+		t.Errorf("the centroid of a 4000Hz tone is %gHz", high)
+	}
+	// the default window leaks more, but the high tone is still brighter
+	d := testConfig(t)
+	low = lastFrame(t, d, sine(d, 200, 0.5, 0.5)).Centroid()
+	high = lastFrame(t, d, sine(d, 4000, 0.5, 0.5)).Centroid()
+	if low >= high {
+		t.Errorf("with the default window a 200Hz tone is %gHz, not below a 4000Hz tone at %gHz", low, high)
+	}
+	if silent := lastFrame(t, c, make([]float64, c.SampleRate/2)).Centroid(); silent != 0 {
+		t.Errorf("the centroid of silence is %gHz, want 0", silent)
+	}
+}
+
+func TestRMS(t *testing.T) {
+	c := testConfig(t, "-style", "waveform")
+	for _, amplitude := range []float64{1, 0.5, 0.1} {
+		// before the window (or the waveform's scaling) touches the samples
+		got := lastFrame(t, c, sine(c, 441, amplitude, 0.5)).RMS()
+		if want := amplitude / math.Sqrt2; math.Abs(got-want) > 1e-3 {
+			t.Errorf("the RMS of a sine of amplitude %g is %g, want %g", amplitude, got, want)
+		}
+	}
+	if got := rms([]float64{0.5, -0.5, 0.5, -0.5}); got != 0.5 {
+		t.Errorf("the RMS of a square wave of 0.5 is %g", got)
+	}
+	if got := rms(make([]float64, 8)); got != 0 {
+		t.Errorf("the RMS of silence is %g", got)
+	}
+}
+
+func TestSamplesAtNeverDrifts(t *testing.T) {
+	for _, tc := range []struct {
+		rate, fps, den int
+	}{
+		{44100, 30, 1},
+		{44100, 60, 1},
+		{44100, 30000, 1001}, // 29.97
+		{48000, 24000, 1001}, // 23.976
+		{44100, 7, 1},
+	} {
+		as := &AudioSource{sampleRate: tc.rate, fps: tc.fps, fpsDen: tc.den}
+		ideal := float64(tc.rate) * float64(tc.den) / float64(tc.fps)
+		lo, hi := int64(math.Floor(ideal)), int64(math.Ceil(ideal))
+		for k := int64(0); k < 100_000; k++ {
+			// every frame is as near the ideal length as a whole sample can be
+			if n := as.samplesAt(k+1) - as.samplesAt(k); n != lo && n != hi {
+				t.Fatalf("%d/%d fps at %dHz: frame %d is %d samples, want %d or %d", tc.fps, tc.den, tc.rate, k, n, lo, hi)
+			}
+		}
+		// and after all of them, less than a sample behind the video
+		k := int64(100_000)
+		if drift := float64(k)*ideal - float64(as.samplesAt(k)); drift < 0 || drift >= 1 {
+			t.Errorf("%d/%d fps at %dHz: %g samples off after %d frames", tc.fps, tc.den, tc.rate, drift, k)
+		}
+	}
+}
+
+func TestReadFrameLengths(t *testing.T) {
+	// 29.97fps, which isn't a whole number of samples a frame
+	c := testConfig(t, "-fps", "30000/1001")
+	// exactly 1001 frames of audio
+	as := testAudioSource(t, c, make([]float64, 1001*c.SampleRate*1001/30000))
+	af := as.NewFrame()
+	frames := 0
+	for as.ReadFrame(af) == nil {
+		frames++
+	}
+	if frames != 1001 {
+		t.Errorf("read %d frames, want 1001", frames)
+	}
+}
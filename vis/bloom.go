@@ -0,0 +1,127 @@
+package vis
+
+import (
+	"image"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// Bloom makes the bright parts of the frame glow: the pixels over the
+// threshold are blurred (with a separable gaussian) and added back on top.
+// It is slow, the blur is 2*(2*radius+1) multiplies for every color of
+// every pixel, so at big sizes it can take longer than the drawing.
+type Bloom struct {
+	threshold float32   // of the brightest color, 0 to 1
+	intensity float32   // how much of the glow is added
+	kernel    []float32 // the gaussian weights, from -radius to radius
+	glow, tmp []float32 // the bright pixels as RGB, and the half blurred ones
+}
+
+// NewBloom creates the bloom from the config, nil for none
+func NewBloom(c *Config) *Bloom {
+	if c.BloomIntensity <= 0 {
+		return nil
+	}
+	r := c.BloomRadius
+	kernel := make([]float32, 2*r+1)
+	// most of the gaussian is within 3 sigma
+	sigma := math.Max(float64(r)/3, 0.5)
+	var sum float32
+	for i := range kernel {
+		x := float64(i - r)
+		kernel[i] = float32(math.Exp(-x * x / (2 * sigma * sigma)))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	n := c.Width * c.Height * 3
+	return &Bloom{
+		threshold: float32(c.BloomThreshold),
+		intensity: float32(c.BloomIntensity),
+		kernel:    kernel,
+		glow:      make([]float32, n),
+		tmp:       make([]float32, n),
+	}
+}
+
+// Apply the bloom to the image, in place
+func (b *Bloom) Apply(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	pix := img.Pix
+	// the bright pass, fading in above the threshold so there's no edge
+	for i, j := 0, 0; j < len(b.glow); i, j = i+4, j+3 {
+		r, g, bl := float32(pix[i])/255, float32(pix[i+1])/255, float32(pix[i+2])/255
+		m := r
+		if g > m {
+			m = g
+		}
+		if bl > m {
+			m = bl
+		}
+		k := float32(0)
+		if m > b.threshold {
+			k = (m - b.threshold) / (1 - b.threshold)
+		}
+		b.glow[j], b.glow[j+1], b.glow[j+2] = r*k, g*k, bl*k
+	}
+	// across, then down
+	b.blur(b.tmp, b.glow, w, h, 3, 3*w)
+	b.blur(b.glow, b.tmp, h, w, 3*w, 3)
+	// and add it back on, brightening the alpha too so the glow shows
+	// on a transparent frame.
+	for i, j := 0, 0; j < len(b.glow); i, j = i+4, j+3 {
+		a := pix[i+3]
+		for k := 0; k < 3; k++ {
+			c := float32(pix[i+k]) + b.glow[j+k]*b.intensity*255
+			if c > 255 {
+				c = 255
+			}
+			pix[i+k] = uint8(c)
+			if pix[i+k] > a {
+				a = pix[i+k]
+			}
+		}
+		pix[i+3] = a
+	}
+}
+
+// blur convolves src with the kernel into dst, along lines of n pixels
+// `step` apart, with `lines` lines `stride` apart. Outside the frame is black.
+// The lines are independent, so they are shared out between the CPUs.
+func (b *Bloom) blur(dst, src []float32, n, lines, step, stride int) {
+	workers := runtime.NumCPU()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			b.blurLines(dst, src, n, from, to, step, stride)
+		}(w*lines/workers, (w+1)*lines/workers)
+	}
+	wg.Wait()
+}
+
+// blurLines blurs lines from up to (not including) to, see blur
+func (b *Bloom) blurLines(dst, src []float32, n, from, to, step, stride int) {
+	r := len(b.kernel) / 2
+	for l := from; l < to; l++ {
+		base := l * stride
+		for x := 0; x < n; x++ {
+			var sr, sg, sb float32
+			for k, wt := range b.kernel {
+				xx := x + k - r
+				if xx < 0 || xx >= n {
+					continue
+				}
+				p := base + xx*step
+				sr += src[p] * wt
+				sg += src[p+1] * wt
+				sb += src[p+2] * wt
+			}
+			p := base + x*step
+			dst[p], dst[p+1], dst[p+2] = sr, sg, sb
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package vis
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestNewBloomKernel(t *testing.T) {
+	if b := NewBloom(&Config{Width: 16, Height: 9, BloomRadius: 4}); b != nil {
+		t.Fatal("a bloom without intensity isn't nil")
+	}
+	b := NewBloom(&Config{Width: 16, Height: 9, BloomRadius: 4, BloomIntensity: 1})
+	if len(b.kernel) != 9 {
+		t.Fatalf("the kernel has %d weights, want 9", len(b.kernel))
+	}
+	var sum float32
+	for i, w := range b.kernel {
+		sum += w
+		if w != b.kernel[len(b.kernel)-1-i] {
+			t.Errorf("the kernel isn't symmetric: %v", b.kernel)
+		}
+		if i > 0 && i <= 4 && w <= b.kernel[i-1] {
+			t.Errorf("the kernel doesn't rise to the middle: %v", b.kernel)
+		}
+	}
+	if math.Abs(float64(sum)-1) > 1e-5 {
+		t.Errorf("the kernel adds up to %v, want 1", sum)
+	}
+}
+
+func TestBloomBelowThreshold(t *testing.T) {
+	b := NewBloom(&Config{Width: 8, Height: 6, BloomRadius: 2, BloomIntensity: 1, BloomThreshold: 0.5})
+	img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for i := range img.Pix {
+		img.Pix[i] = 100
+	}
+	img.Pix[3] = 255
+	want := append([]uint8(nil), img.Pix...)
+	b.Apply(img)
+	for i := range want {
+		if img.Pix[i] != want[i] {
+			t.Fatalf("byte %d is %d, want it left at %d", i, img.Pix[i], want[i])
+		}
+	}
+}
+
+// TestBloomGlow blooms one white pixel off center of a transparent frame,
+// which isn't square so a mix up of the rows and columns shows.
+func TestBloomGlow(t *testing.T) {
+	const w, h, r = 21, 15, 3
+	b := NewBloom(&Config{Width: w, Height: h, BloomRadius: r, BloomIntensity: 1, BloomThreshold: 0.5})
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	x0, y0 := 6, 5
+	img.SetRGBA(x0, y0, color.RGBA{0xff, 0xff, 0xff, 0xff})
+	b.Apply(img)
+
+	if got := img.RGBAAt(x0, y0); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("the white pixel is %v", got)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.RGBAAt(x, y)
+			if c.R != c.G || c.G != c.B || c.A != c.R {
+				t.Fatalf("(%d, %d) is %v, want a white glow with the alpha to show it", x, y, c)
+			}
+			near := abs(x-x0) <= r && abs(y-y0) <= r
+			if near && c.R == 0 && abs(x-x0)+abs(y-y0) <= 2 {
+				t.Errorf("(%d, %d) doesn't glow", x, y)
+			}
+			if !near && c.R != 0 {
+				t.Errorf("(%d, %d) glows at %d, past the radius", x, y, c.R)
+			}
+			// the same each side, across and down
+			if mx := 2*x0 - x; mx >= 0 && mx < w && img.RGBAAt(mx, y) != c {
+				t.Errorf("(%d, %d) is %v but (%d, %d) is %v", x, y, c, mx, y, img.RGBAAt(mx, y))
+			}
+			if my := 2*y0 - y; my >= 0 && my < h && img.RGBAAt(x, my) != c {
+				t.Errorf("(%d, %d) is %v but (%d, %d) is %v", x, y, c, x, my, img.RGBAAt(x, my))
+			}
+		}
+	}
+	// the blur is the same both ways
+	if a, b := img.RGBAAt(x0+1, y0), img.RGBAAt(x0, y0+1); a != b {
+		t.Errorf("the glow is %v across but %v down", a, b)
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func BenchmarkBloom(b *testing.B) {
+	for _, size := range []string{"720p", "1080p"} {
+		b.Run(size, func(b *testing.B) {
+			// the default radius
+			c, err := NewConfig("-resolution", size, "-bloom", "0.8")
+			if err != nil {
+				b.Fatalf("NewConfig: %v", err)
+			}
+			bloom := NewBloom(c)
+			img := benchFrame(c.Width, c.Height)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bloom.Apply(img)
+			}
+		})
+	}
+}
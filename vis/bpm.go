@@ -0,0 +1,188 @@
+package vis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+)
+
+// the range of tempos we look for, a tempo outside it is found as its
+// double or half.
+const (
+	minBPM = 60
+	maxBPM = 200
+	// the tempo people tend to hear, to choose between a tempo and its
+	// double or half when they fit about as well.
+	preferredBPM = 120
+	// how well the onsets must line up a beat apart, as a fraction of
+	// how much they vary, for it to be a beat rather than noise.
+	minBeatCorrelation = 0.2
+)
+
+// TempoEstimator guesses the tempo of the track: the beats are where the
+// spectrum suddenly gets louder (the onsets), so the lag at which that
+// envelope best lines up with itself (autocorrelation) is the beat.
+type TempoEstimator struct {
+	fps      float64
+	prev     []float64 // the last frame's (log) magnitudes
+	envelope []float64 // the onset strength of each frame
+}
+
+// NewTempoEstimator creates an estimator for frames at fps
+func NewTempoEstimator(fps float64) *TempoEstimator {
+	return &TempoEstimator{fps: fps}
+}
+
+// Add is the onFrame callback for AudioSource.StartProcessing
+func (te *TempoEstimator) Add(af *AudioFrame) error {
+	// only the first half is useful, the rest is the mirror image
+	n := len(af.freq) / 2
+	first := te.prev == nil
+	if first {
+		te.prev = make([]float64, n)
+	}
+	// the spectral flux: how much louder each band got, on a log
+	// scale so quiet instruments count too.
+	var flux float64
+	for i := 0; i < n; i++ {
+		m := math.Log1p(af.freq[i])
+		if d := m - te.prev[i]; d > 0 {
+			flux += d
+		}
+		te.prev[i] = m
+	}
+	if first {
+		// everything got louder from nothing, that isn't a beat
+		flux = 0
+	}
+	te.envelope = append(te.envelope, finite(flux))
+	return nil
+}
+
+// Estimate is the tempo in BPM and the time in seconds of the first beat,
+// 0 BPM if there isn't enough of a beat to tell.
+func (te *TempoEstimator) Estimate() (bpm, offset float64) {
+	env := te.envelope
+	lagMin := int(math.Floor(te.fps * 60 / maxBPM))
+	lagMax := int(math.Ceil(te.fps * 60 / minBPM))
+	if lagMin < 1 || len(env) < 2*lagMax+2 {
+		return 0, 0
+	}
+	var mean float64
+	for _, x := range env {
+		mean += x
+	}
+	mean /= float64(len(env))
+	centered := make([]float64, len(env))
+	var variance float64
+	for i, x := range env {
+		centered[i] = x - mean
+		variance += centered[i] * centered[i]
+	}
+	variance /= float64(len(env))
+	// the autocorrelation at each lag in the range, and either side of it
+	// for the interpolation below.
+	corr := make([]float64, lagMax+2)
+	for lag := lagMin - 1; lag <= lagMax+1; lag++ {
+		if lag < 1 {
+			continue
+		}
+		var sum float64
+		for i := 0; i+lag < len(centered); i++ {
+			sum += centered[i] * centered[i+lag]
+		}
+		corr[lag] = sum / float64(len(centered)-lag)
+	}
+	best, bestScore := 0, 0.0
+	for lag := lagMin; lag <= lagMax; lag++ {
+		// gently prefer the tempos near the one people hear
+		octaves := math.Log2(te.fps * 60 / float64(lag) / preferredBPM)
+		score := corr[lag] * math.Exp(-octaves*octaves/2)
+		if score > bestScore {
+			best, bestScore = lag, score
+		}
+	}
+	if best == 0 || corr[best] < minBeatCorrelation*variance {
+		// nothing repeats, or not enough to be more than chance
+		return 0, 0
+	}
+	// the peak is between frames, fit a parabola through it and its neighbours
+	lag := float64(best)
+	a, b, c := corr[best-1], corr[best], corr[best+1]
+	if d := a - 2*b + c; d < 0 {
+		lag += (a - c) / (2 * d)
+	}
+	// the first beat is the phase that catches the most onsets
+	phase, phaseScore := 0, math.Inf(-1)
+	for p := 0; p < best; p++ {
+		var sum float64
+		for t := float64(p); int(math.Round(t)) < len(env); t += lag {
+			sum += env[int(math.Round(t))]
+		}
+		if sum > phaseScore {
+			phase, phaseScore = p, sum
+		}
+	}
+	return te.fps * 60 / lag, float64(phase) / te.fps
+}
+
+// FindTempo estimates the tempo of the audio for a config (or any of its
+// layers) with AutoBPM, and sets it in their BPM, and BeatOffset if they
+// didn't give one.
+func FindTempo(ctx context.Context, c *Config) error {
+	auto := autoTempo(c)
+	if len(auto) == 0 {
+		return nil
+	}
+	bpm, offset, err := estimateTempo(ctx, c)
+	if err != nil {
+		return fmt.Errorf("Can't estimate the tempo '-bpm auto': %s", err)
+	}
+	if bpm == 0 {
+		return errors.New("There's no clear beat to find the tempo of, give it instead '-bpm'")
+	}
+	for _, ac := range auto {
+		ac.BPM, ac.AutoBPM = bpm, false
+		if ac.BeatOffset == 0 {
+			ac.BeatOffset = offset
+		}
+		log.Printf("Estimated the tempo as -bpm %.2f -beat-offset %.2f", ac.BPM, ac.BeatOffset)
+	}
+	return nil
+}
+
+// autoTempo is the config and those of its layers with AutoBPM
+func autoTempo(c *Config) []*Config {
+	var auto []*Config
+	if c.AutoBPM {
+		auto = append(auto, c)
+	}
+	for i := range c.Layers {
+		if c.Layers[i].AutoBPM {
+			auto = append(auto, &c.Layers[i])
+		}
+	}
+	return auto
+}
+
+// estimateTempo reads through the audio in the config for Estimate,
+// for '-bpm auto'.
+func estimateTempo(ctx context.Context, c *Config) (bpm, offset float64, err error) {
+	// only the spectrum, whatever we are drawing
+	ec := *c
+	ec.Style = styleSpectrum
+	ec.Layers = nil
+	ec.Stems = nil
+	process, err := openAudio(ctx, &ec, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	te := NewTempoEstimator(frameRate(&ec))
+	if err := process(ctx, te.Add); err != nil {
+		return 0, 0, err
+	}
+	bpm, offset = te.Estimate()
+	return bpm, offset, nil
+}
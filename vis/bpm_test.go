@@ -0,0 +1,98 @@
+package vis
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// clicks is a click track: a short burst of noise on every beat, the
+// first at offset seconds.
+func clicks(c *Config, bpm, offset, seconds float64) []float64 {
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]float64, int(seconds*float64(c.SampleRate)))
+	click := int(0.02 * float64(c.SampleRate))
+	for beat := offset; beat < seconds; beat += 60 / bpm {
+		start := int(beat * float64(c.SampleRate))
+		for i := 0; i < click && start+i < len(samples); i++ {
+			decay := 1 - float64(i)/float64(click)
+			samples[start+i] = 0.8 * decay * (2*rng.Float64() - 1)
+		}
+	}
+	return samples
+}
+
+// estimate runs the samples through a TempoEstimator
+func estimate(t *testing.T, c *Config, samples []float64) (bpm, offset float64) {
+	t.Helper()
+	as := testAudioSource(t, c, samples)
+	te := NewTempoEstimator(frameRate(c))
+	af := as.NewFrame()
+	for as.ReadFrame(af) == nil {
+		if err := te.Add(af); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return te.Estimate()
+}
+
+func TestTempoEstimate(t *testing.T) {
+	c := testConfig(t)
+	for _, tc := range []struct{ bpm, offset float64 }{
+		{128, 0.25},
+		{90, 0.5},
+		{150, 0.1},
+	} {
+		bpm, offset := estimate(t, c, clicks(c, tc.bpm, tc.offset, 20))
+		if math.Abs(bpm-tc.bpm) > 1 {
+			t.Errorf("a click track at %g BPM is estimated at %.2f BPM", tc.bpm, bpm)
+			continue
+		}
+		// any beat will do for the offset, to within a couple of frames
+		beat := 60 / tc.bpm
+		d := math.Mod(offset-tc.offset+10*beat, beat)
+		if d > beat/2 {
+			d -= beat
+		}
+		if math.Abs(d) > 2/frameRate(c) {
+			t.Errorf("a click track at %g BPM from %gs is estimated to start at %.3fs", tc.bpm, tc.offset, offset)
+		}
+	}
+}
+
+func TestTempoEstimateNoise(t *testing.T) {
+	c := testConfig(t)
+	hiss := noise(c, 0.3, 20)
+	if bpm, _ := estimate(t, c, hiss); bpm != 0 {
+		t.Errorf("noise is estimated at %.2f BPM, want 0", bpm)
+	}
+	// but the clicks can be heard over it
+	beat := clicks(c, 128, 0, 20)
+	for i := range beat {
+		beat[i] += hiss[i]
+	}
+	if bpm, _ := estimate(t, c, beat); math.Abs(bpm-128) > 1 {
+		t.Errorf("a click track at 128 BPM in noise is estimated at %.2f BPM", bpm)
+	}
+}
+
+func TestTempoEstimateNoBeat(t *testing.T) {
+	c := testConfig(t)
+	if bpm, _ := estimate(t, c, make([]float64, 10*c.SampleRate)); bpm != 0 {
+		t.Errorf("silence is estimated at %.2f BPM, want 0", bpm)
+	}
+	// too short to have a few beats at the slowest tempo
+	if bpm, _ := estimate(t, c, clicks(c, 120, 0, 1)); bpm != 0 {
+		t.Errorf("a second of clicks is estimated at %.2f BPM, want 0", bpm)
+	}
+}
+
+// noise is white noise of the amplitude
+func noise(c *Config, amplitude, seconds float64) []float64 {
+	rng := rand.New(rand.NewSource(2))
+	samples := make([]float64, int(seconds*float64(c.SampleRate)))
+	for i := range samples {
+		samples[i] = amplitude * (2*rng.Float64() - 1)
+	}
+	return samples
+}
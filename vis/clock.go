@@ -0,0 +1,73 @@
+package vis
+
+import (
+	"log"
+	"time"
+)
+
+// how far behind a real-time output can get before we drop frames
+const lateFrames = 3
+
+// FrameClock paces a real-time output (the preview) against the wall
+// clock. If drawing (or the player) can't keep up, a frame that is already
+// late is dropped: it isn't drawn or sent at all. The preview timestamps
+// each frame as it arrives, so the video just skips ahead and stays with
+// the audio. So those timestamps are right, Wait holds back a frame that
+// is early. A nil *FrameClock never drops or waits, which is what saving
+// to a file wants.
+type FrameClock struct {
+	fps     float64
+	start   time.Time // when the first frame was due
+	frames  int       // asked about so far
+	dropped int
+
+	// the wall clock, so the tests can have their own
+	now   func() time.Time
+	sleep func(d time.Duration)
+}
+
+// NewFrameClock creates a clock for frames at fps, starting at the first frame
+func NewFrameClock(fps float64) *FrameClock {
+	return &FrameClock{fps: fps, now: time.Now, sleep: time.Sleep}
+}
+
+// due is when frame i should be sent
+func (fc *FrameClock) due(i int) time.Time {
+	return fc.start.Add(time.Duration(float64(i) / fc.fps * float64(time.Second)))
+}
+
+// Late is true if the next frame should be dropped to catch up
+func (fc *FrameClock) Late() bool {
+	if fc == nil {
+		return false
+	}
+	now := fc.now()
+	if fc.frames == 0 {
+		fc.start = now
+	}
+	due := fc.due(fc.frames)
+	fc.frames++
+	if now.Sub(due) < time.Duration(lateFrames/fc.fps*float64(time.Second)) {
+		return false
+	}
+	fc.dropped++
+	return true
+}
+
+// Wait until the frame Late was last asked about is due, if it isn't yet
+func (fc *FrameClock) Wait() {
+	if fc == nil || fc.frames == 0 {
+		return
+	}
+	if d := fc.due(fc.frames - 1).Sub(fc.now()); d > 0 {
+		fc.sleep(d)
+	}
+}
+
+// Report logs how many frames were dropped, if any
+func (fc *FrameClock) Report() {
+	if fc == nil || fc.dropped == 0 {
+		return
+	}
+	log.Printf("Dropped %d of %d frames to keep up, a smaller size or frame rate would help", fc.dropped, fc.frames)
+}
@@ -0,0 +1,103 @@
+package vis
+
+import (
+	"context"
+	"image"
+	"testing"
+	"time"
+)
+
+// testClock is a FrameClock at 10fps on a wall clock the test moves
+func testClock() (*FrameClock, *time.Time, *time.Duration) {
+	now := time.Unix(0, 0)
+	var slept time.Duration
+	fc := NewFrameClock(10)
+	fc.now = func() time.Time { return now }
+	fc.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+	return fc, &now, &slept
+}
+
+func TestFrameClockDrops(t *testing.T) {
+	fc, now, _ := testClock()
+	// on time, then a bit late but not lateFrames late
+	for i, late := range []time.Duration{0, 150 * time.Millisecond, 250 * time.Millisecond} {
+		*now = time.Unix(0, 0).Add(time.Duration(i)*100*time.Millisecond + late)
+		if fc.Late() {
+			t.Fatalf("frame %d, %v late, was dropped", i, late)
+		}
+	}
+	// more than lateFrames (300ms) behind
+	*now = time.Unix(0, 0).Add(3*100*time.Millisecond + 300*time.Millisecond)
+	if !fc.Late() {
+		t.Fatal("frame 3, 300ms late, wasn't dropped")
+	}
+	if fc.dropped != 1 || fc.frames != 4 {
+		t.Fatalf("dropped %d of %d frames, want 1 of 4", fc.dropped, fc.frames)
+	}
+}
+
+func TestFrameClockWaits(t *testing.T) {
+	fc, now, slept := testClock()
+	fc.Late()
+	fc.Wait()
+	if *slept != 0 {
+		t.Fatalf("waited %v for the first frame, want 0", *slept)
+	}
+	// drawn in 30ms, so it waits the rest of the 100ms
+	*now = now.Add(30 * time.Millisecond)
+	fc.Late()
+	fc.Wait()
+	if *slept != 70*time.Millisecond {
+		t.Fatalf("waited %v for the second frame, want 70ms", *slept)
+	}
+	// already late, so no waiting
+	*now = now.Add(150 * time.Millisecond)
+	fc.Late()
+	fc.Wait()
+	if *slept != 70*time.Millisecond {
+		t.Fatalf("waited %v more for a late frame, want none", *slept-70*time.Millisecond)
+	}
+	// a nil clock never waits
+	var nc *FrameClock
+	nc.Wait()
+}
+
+func TestRenderFramesSkipsDropped(t *testing.T) {
+	c := testConfig(t, "-width", "32", "-height", "32")
+	vis, err := NewVisualisation(c)
+	if err != nil {
+		t.Fatalf("NewVisualisation: %v", err)
+	}
+	fc, now, _ := testClock()
+	process := func(ctx context.Context, onFrame func(af *AudioFrame) error) error {
+		af := &AudioFrame{sampleRate: c.SampleRate, gain: c.MagnitudeGain, freq: make([]float64, 64)}
+		for i := 0; i < 10; i++ {
+			if i >= 5 {
+				// drawing has fallen a second behind
+				*now = now.Add(time.Second)
+			}
+			if err := onFrame(af); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	sent := 0
+	frames, err := renderFrames(context.Background(), c, vis, process, func(*image.RGBA) error {
+		sent++
+		return nil
+	}, fc, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 10 || sent != 5 || fc.dropped != 5 {
+		t.Fatalf("sent %d of %d frames with %d dropped, want 5 of 10 with 5 dropped", sent, frames, fc.dropped)
+	}
+	// the dropped frames still count in the history
+	if n, _ := vis.Latest(); n != 9 {
+		t.Fatalf("the latest frame is %d, want 9", n)
+	}
+}
@@ -0,0 +1,111 @@
+package vis
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// containerAudioCodecs are the audio codecs each output container can hold,
+// and the codec to transcode to when the source isn't one of them.
+// containers we don't know about are left to copy (and hope).
+var containerAudioCodecs = map[string]struct {
+	compatible []string
+	fallback   []string
+}{
+	".mp4":  {[]string{"aac", "mp3", "alac", "ac3", "eac3"}, []string{"aac", "-b:a", "256k"}},
+	".m4v":  {[]string{"aac", "mp3", "alac", "ac3", "eac3"}, []string{"aac", "-b:a", "256k"}},
+	".mov":  {[]string{"aac", "mp3", "alac", "ac3", "eac3", "pcm_s16le", "pcm_s24le"}, []string{"aac", "-b:a", "256k"}},
+	".webm": {[]string{"opus", "vorbis"}, []string{"libopus", "-b:a", "192k"}},
+	".avi":  {[]string{"mp3", "ac3", "pcm_s16le"}, []string{"libmp3lame", "-b:a", "320k"}},
+	// matroska takes anything
+}
+
+// muxerContainers are the containers above for the '-format' muxer names,
+// so we know what a forced format can hold.
+var muxerContainers = map[string]string{
+	"mp4":      ".mp4",
+	"ipod":     ".m4v",
+	"mov":      ".mov",
+	"webm":     ".webm",
+	"avi":      ".avi",
+	"matroska": ".mkv",
+}
+
+// outputContainer is the (lowercase) extension of the output's container,
+// from the forced format if there is one, otherwise the file name.
+func outputContainer(c *Config) string {
+	if c.Format != "" {
+		return muxerContainers[c.Format]
+	}
+	return strings.ToLower(filepath.Ext(c.VideoFile))
+}
+
+// compatibleAudioOptions returns the audio codec options for the output.
+// We prefer to copy the audio, but if the source codec can't go in the
+// output container we transcode it instead of letting the mux fail.
+func compatibleAudioOptions(sourceCodec, ext string) []string {
+	container, ok := containerAudioCodecs[ext]
+	if !ok {
+		return []string{"copy"}
+	}
+	for _, c := range container.compatible {
+		if c == sourceCodec {
+			return []string{"copy"}
+		}
+	}
+	return container.fallback
+}
+
+// muxErrors are the bits of ffmpeg's log that mean the container can't hold the codec
+var muxErrors = []string{
+	"could not find tag for codec",
+	"codec not currently supported in container",
+	"incorrect codec parameters",
+}
+
+// audioCopyFails tries copying a moment of the audio into a temporary file
+// of the same type as the output, and checks ffmpeg's log for mux errors.
+// Any other failure is left for the real encode to report.
+func audioCopyFails(c *Config) bool {
+	tmp, err := os.CreateTemp("", "visualisation-*"+outputContainer(c))
+	if err != nil {
+		return false
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := append([]string{"-v", "error"}, c.InputOptions...)
+	args = append(args,
+		"-i", c.AudioFile,
+		"-t", "0.1",
+		"-map", "0:a:0",
+		"-c:a", "copy",
+	)
+	if c.Format != "" {
+		args = append(args, "-f", c.Format)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(c.FFMpegPath, append(args, "-y", tmp.Name())...)
+	cmd.Stderr = &stderr
+	if cmd.Run() == nil {
+		return false
+	}
+	out := strings.ToLower(stderr.String())
+	for _, e := range muxErrors {
+		if strings.Contains(out, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// transcodeAudioOptions are the options to use when we can't copy the audio
+func transcodeAudioOptions(ext string) []string {
+	if container, ok := containerAudioCodecs[ext]; ok {
+		return container.fallback
+	}
+	return []string{"aac", "-b:a", "256k"}
+}
@@ -0,0 +1,117 @@
+package vis
+
+import (
+	"flag"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputContainer(t *testing.T) {
+	for _, tc := range []struct {
+		video, format, want string
+	}{
+		{"out.MP4", "", ".mp4"},
+		{"out/video.webm", "", ".webm"},
+		// the forced format wins
+		{"out.bin", "matroska", ".mkv"},
+		{"-", "mov", ".mov"},
+		// we don't know what this one holds
+		{"-", "nut", ""},
+	} {
+		if got := outputContainer(&Config{VideoFile: tc.video, Format: tc.format}); got != tc.want {
+			t.Errorf("outputContainer(%q, %q) = %q, want %q", tc.video, tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestCompatibleAudioOptions(t *testing.T) {
+	for _, tc := range []struct {
+		codec, ext string
+		want       []string
+	}{
+		{"aac", ".mp4", []string{"copy"}},
+		{"flac", ".mp4", []string{"aac", "-b:a", "256k"}},
+		{"pcm_s16le", ".mov", []string{"copy"}},
+		{"aac", ".webm", []string{"libopus", "-b:a", "192k"}},
+		{"opus", ".webm", []string{"copy"}},
+		{"flac", ".avi", []string{"libmp3lame", "-b:a", "320k"}},
+		// matroska, and anything we don't know, copies
+		{"flac", ".mkv", []string{"copy"}},
+		{"flac", "", []string{"copy"}},
+	} {
+		if got := compatibleAudioOptions(tc.codec, tc.ext); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("compatibleAudioOptions(%q, %q) = %q, want %q", tc.codec, tc.ext, got, tc.want)
+		}
+	}
+}
+
+func TestTranscodeAudioOptions(t *testing.T) {
+	if got, want := transcodeAudioOptions(".webm"), []string{"libopus", "-b:a", "192k"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("transcodeAudioOptions(.webm) = %q, want %q", got, want)
+	}
+	// aac goes nearly anywhere
+	if got, want := transcodeAudioOptions(".mkv"), []string{"aac", "-b:a", "256k"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("transcodeAudioOptions(.mkv) = %q, want %q", got, want)
+	}
+}
+
+func TestAudioCopyFails(t *testing.T) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("needs ffmpeg:", err)
+	}
+	wav := filepath.Join(t.TempDir(), "sweep.wav")
+	if err := WriteSweep(wav, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		video string
+		want  bool
+	}{
+		// mp4 can't hold 16bit PCM, matroska can
+		{"out.mp4", true},
+		{"out.mkv", false},
+	} {
+		c := &Config{FFMpegPath: ffmpeg, AudioFile: wav, VideoFile: tc.video}
+		if got := audioCopyFails(c); got != tc.want {
+			t.Errorf("audioCopyFails(wav into %s) = %v, want %v", tc.video, got, tc.want)
+		}
+	}
+}
+
+// outputConfig applies the output flags in args to a default config
+func outputConfig(args ...string) (*Config, error) {
+	fs := flag.NewFlagSet("output", flag.ContinueOnError)
+	of := AddOutputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c := DefaultConfig()
+	return c, of.Apply(c)
+}
+
+func TestQualityAndBitrate(t *testing.T) {
+	if _, err := outputConfig("-bitrate", "4M", "-quality", "high"); err == nil || !strings.Contains(err.Error(), "'-quality'") {
+		t.Errorf("-bitrate with -quality = %v, want an error", err)
+	}
+	// the default quality is no preset given
+	c, err := outputConfig("-bitrate", "4M")
+	if err != nil {
+		t.Fatalf("-bitrate: %v", err)
+	}
+	if !reflect.DeepEqual(c.VideoCodecAndOptions, bitrateVideoOptions) {
+		t.Errorf("-bitrate options = %q, want %q", c.VideoCodecAndOptions, bitrateVideoOptions)
+	}
+	c, err = outputConfig("-quality", "high")
+	if err != nil {
+		t.Fatalf("-quality: %v", err)
+	}
+	want := append([]string(nil), qualityPresets["high"]...)
+	c.VideoCodecAndOptions[0] = "changed"
+	if !reflect.DeepEqual(qualityPresets["high"], want) {
+		t.Errorf("changing the config's options changed the preset to %q", qualityPresets["high"])
+	}
+}
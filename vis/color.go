@@ -0,0 +1,188 @@
+package vis
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// hsv converts a hue (0-360 degrees), saturation and value (0-1) to a color
+func hsv(h, s, v float64) color.RGBA {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return color.RGBA{
+		uint8(math.Round((r + m) * 0xff)),
+		uint8(math.Round((g + m) * 0xff)),
+		uint8(math.Round((b + m) * 0xff)),
+		0xff,
+	}
+}
+
+// toHSV is the hue (0-360 degrees), saturation and value of the
+// r, g, b (0-1). Greys have no hue, so it is 0.
+func toHSV(r, g, b float64) (h, s, v float64) {
+	hi := math.Max(r, math.Max(g, b))
+	lo := math.Min(r, math.Min(g, b))
+	d := hi - lo
+	if d == 0 {
+		return 0, 0, hi
+	}
+	switch hi {
+	case r:
+		h = 60 * math.Mod((g-b)/d, 6)
+	case g:
+		h = 60 * ((b-r)/d + 2)
+	default:
+		h = 60 * ((r-g)/d + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+	return h, d / hi, hi
+}
+
+// rotateHue turns the color round the color wheel by deg degrees,
+// keeping its saturation, value and alpha. Greys (and white) don't change.
+func rotateHue(c color.Color, deg float64) color.Color {
+	r, g, b, a := c.RGBA()
+	if a == 0 || (r == g && g == b) {
+		return c
+	}
+	// back to straight (not premultiplied) 0-1
+	h, s, v := toHSV(float64(r)/float64(a), float64(g)/float64(a), float64(b)/float64(a))
+	out := hsv(h+deg, s, v)
+	out.A = uint8(a >> 8)
+	// hsv is opaque, premultiply for the alpha
+	out.R = uint8(uint32(out.R) * a / 0xffff)
+	out.G = uint8(uint32(out.G) * a / 0xffff)
+	out.B = uint8(uint32(out.B) * a / 0xffff)
+	return out
+}
+
+// parseHexColor reads an opaque color as "#rrggbb" (the # is optional)
+func parseHexColor(s string) (color.RGBA, error) {
+	c := color.RGBA{A: 0xff}
+	if len(s) > 0 && s[0] == '#' {
+		s = s[1:]
+	}
+	if len(s) != 6 {
+		return c, fmt.Errorf("%q is not a #rrggbb color", s)
+	}
+	if _, err := fmt.Sscanf(s, "%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, fmt.Errorf("%q is not a #rrggbb color", s)
+	}
+	return c, nil
+}
+
+// mixColors is the color t (0 to 1) of the way from a to b
+func mixColors(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t))
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+}
+
+// the color spaces gradients can be mixed in
+const (
+	colorSpaceRGB = "rgb" // straight lines between the colors, muddy in the middle
+	colorSpaceHSV = "hsv" // round the color wheel, vivid but can pass other hues
+	colorSpaceLab = "lab" // CIELAB, even steps to the eye
+)
+
+// mixColorsIn is the color t (0 to 1) of the way from a to b, mixed in
+// the color space. The colors must be opaque.
+func mixColorsIn(space string, a, b color.RGBA, t float64) color.RGBA {
+	switch space {
+	case colorSpaceHSV:
+		ha, sa, va := toHSV(float64(a.R)/0xff, float64(a.G)/0xff, float64(a.B)/0xff)
+		hb, sb, vb := toHSV(float64(b.R)/0xff, float64(b.G)/0xff, float64(b.B)/0xff)
+		// a grey (or white) takes the other's hue, rather than going via red
+		if sa == 0 {
+			ha = hb
+		} else if sb == 0 {
+			hb = ha
+		}
+		// the short way round the wheel
+		dh := math.Mod(hb-ha+540, 360) - 180
+		return hsv(ha+dh*t, sa+(sb-sa)*t, va+(vb-va)*t)
+	case colorSpaceLab:
+		la, aa, ba := toLab(a)
+		lb, ab, bb := toLab(b)
+		return fromLab(la+(lb-la)*t, aa+(ab-aa)*t, ba+(bb-ba)*t)
+	}
+	return mixColors(a, b, t)
+}
+
+// the D65 white point, for CIELAB
+const labXn, labYn, labZn = 0.95047, 1.0, 1.08883
+
+// toLab converts an sRGB color to CIELAB
+func toLab(c color.RGBA) (l, a, b float64) {
+	linear := func(v uint8) float64 {
+		x := float64(v) / 0xff
+		if x <= 0.04045 {
+			return x / 12.92
+		}
+		return math.Pow((x+0.055)/1.055, 2.4)
+	}
+	r, g, bl := linear(c.R), linear(c.G), linear(c.B)
+	x := (0.4124*r + 0.3576*g + 0.1805*bl) / labXn
+	y := (0.2126*r + 0.7152*g + 0.0722*bl) / labYn
+	z := (0.0193*r + 0.1192*g + 0.9505*bl) / labZn
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// fromLab converts a CIELAB color back to (opaque) sRGB, clipping
+// anything outside it.
+func fromLab(l, a, b float64) color.RGBA {
+	fy := (l + 16) / 116
+	fx, fz := fy+a/500, fy-b/200
+	finv := func(t float64) float64 {
+		if t*t*t > 216.0/24389 {
+			return t * t * t
+		}
+		return (116*t - 16) * 27 / 24389
+	}
+	x, y, z := finv(fx)*labXn, finv(fy)*labYn, finv(fz)*labZn
+	gamma := func(v float64) uint8 {
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		return uint8(math.Round(math.Max(0, math.Min(1, v)) * 0xff))
+	}
+	return color.RGBA{
+		gamma(3.2406*x - 1.5372*y - 0.4986*z),
+		gamma(-0.9689*x + 1.8758*y + 0.0415*z),
+		gamma(0.0557*x - 0.2040*y + 1.0570*z),
+		0xff,
+	}
+}
@@ -0,0 +1,105 @@
+package vis
+
+import (
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestHSVRoundTrip(t *testing.T) {
+	for _, c := range []color.RGBA{
+		{0xff, 0, 0, 0xff}, {0, 0xff, 0, 0xff}, {0, 0, 0xff, 0xff},
+		{0x12, 0x34, 0x56, 0xff}, {0xfe, 0xdc, 0xba, 0xff}, {0x80, 0x80, 0x80, 0xff},
+	} {
+		h, s, v := toHSV(float64(c.R)/0xff, float64(c.G)/0xff, float64(c.B)/0xff)
+		if got := hsv(h, s, v); got != c {
+			t.Errorf("hsv(toHSV(%v)) = %v", c, got)
+		}
+	}
+}
+
+func TestLab(t *testing.T) {
+	for _, tc := range []struct {
+		c       color.RGBA
+		l, a, b float64
+	}{
+		{color.RGBA{0xff, 0xff, 0xff, 0xff}, 100, 0, 0},
+		{color.RGBA{0, 0, 0, 0xff}, 0, 0, 0},
+		{color.RGBA{0xff, 0, 0, 0xff}, 53.24, 80.09, 67.20},
+		{color.RGBA{0, 0, 0xff, 0xff}, 32.30, 79.19, -107.86},
+	} {
+		l, a, b := toLab(tc.c)
+		if math.Abs(l-tc.l) > 0.05 || math.Abs(a-tc.a) > 0.05 || math.Abs(b-tc.b) > 0.05 {
+			t.Errorf("toLab(%v) = %.2f, %.2f, %.2f, want %.2f, %.2f, %.2f", tc.c, l, a, b, tc.l, tc.a, tc.b)
+		}
+	}
+	// every palette color comes back exactly
+	for _, s := range spectrumStyles() {
+		c := color.RGBAModel.Convert(s.color).(color.RGBA)
+		if got := fromLab(toLab(c)); got != c {
+			t.Errorf("fromLab(toLab(%v)) = %v", c, got)
+		}
+	}
+}
+
+func TestMixColorsIn(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	grey := color.RGBA{0x80, 0x80, 0x80, 0xff}
+	for _, space := range []string{colorSpaceRGB, colorSpaceHSV, colorSpaceLab} {
+		if got := mixColorsIn(space, red, blue, 0); got != red {
+			t.Errorf("%s: the start is %v, want %v", space, got, red)
+		}
+		if got := mixColorsIn(space, red, blue, 1); got != blue {
+			t.Errorf("%s: the end is %v, want %v", space, got, blue)
+		}
+	}
+	for _, tc := range []struct {
+		name  string
+		space string
+		a, b  color.RGBA
+		want  color.RGBA
+	}{
+		{"rgb", colorSpaceRGB, red, blue, color.RGBA{0x80, 0, 0x80, 0xff}},
+		// full strength magenta, not the darker rgb middle
+		{"hsv", colorSpaceHSV, red, blue, color.RGBA{0xff, 0, 0xff, 0xff}},
+		// the short way round from 330 to 30 degrees is through red
+		{"hsv short way", colorSpaceHSV, hsv(330, 1, 1), hsv(30, 1, 1), red},
+		// the grey has no hue, so it only fades the red
+		{"hsv grey", colorSpaceHSV, grey, red, hsv(0, 0.5, (0x80/255.0+1)/2)},
+	} {
+		if got := mixColorsIn(tc.space, tc.a, tc.b, 0.5); got != tc.want {
+			t.Errorf("%s: the middle of %v and %v is %v, want %v", tc.name, tc.a, tc.b, got, tc.want)
+		}
+	}
+	// lab is half way in lightness
+	l, _, _ := toLab(mixColorsIn(colorSpaceLab, color.RGBA{0, 0, 0, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff}, 0.5))
+	if math.Abs(l-50) > 0.5 {
+		t.Errorf("lab: the middle of black and white has lightness %.2f, want 50", l)
+	}
+}
+
+func TestHistoryStyles(t *testing.T) {
+	defaults := spectrumStyles()
+	n := len(defaults)
+	if got := historyStyles(n, colorSpaceHSV); !reflect.DeepEqual(got, defaults) {
+		t.Error("one per palette color isn't the palette")
+	}
+	palette := func(i int) color.RGBA { return color.RGBAModel.Convert(defaults[i].color).(color.RGBA) }
+	for _, space := range []string{colorSpaceRGB, colorSpaceHSV, colorSpaceLab} {
+		styles := historyStyles(2*n-1, space)
+		if len(styles) != 2*n-1 {
+			t.Fatalf("%s: %d styles, want %d", space, len(styles), 2*n-1)
+		}
+		// every other one is a palette color, with a mix between
+		for i := 0; i < n; i++ {
+			if got := color.RGBAModel.Convert(styles[2*i].color).(color.RGBA); got != palette(i) {
+				t.Errorf("%s: style %d is %v, want palette color %v", space, 2*i, got, palette(i))
+			}
+		}
+		if got, want := color.RGBAModel.Convert(styles[1].color).(color.RGBA), mixColorsIn(space, palette(0), palette(1), 0.5); got != want {
+			t.Errorf("%s: style 1 is %v, want %v", space, got, want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package vis
+
+import (
+	"errors"
+	"image/color"
+	"log"
+	"os/exec"
+	"sync"
+)
+
+// read in an MP3
+// process it to get some frequency analysis at 30fps.
+// draw a circular spectrum analyser like trap nation.
+// Lets assume we have ffmpeg.
+// ffmpeg a give us a raw pcm stream.
+
+// need a file system to store the file so we can get ffmpeg to load it twice.
+type Config struct {
+	FFMpegPath  string
+	FFProbePath string // may be empty if we couldn't find it
+	FFPlayPath  string // only needed to preview
+
+	// audio input config
+	AudioFile         string
+	AudioFile2        string   // optional second track to crossfade into
+	InputOptions      []string // passed to ffmpeg as they are before each audio "-i", for inputs it can't work out (e.g. raw PCM)
+	CrossfadeStart    float64  // seconds into AudioFile the crossfade starts (and AudioFile2 begins)
+	CrossfadeDuration float64  // seconds the crossfade lasts
+	LoudNorm          bool     // normalise the loudness of the analysed audio
+	LoudNormTarget    float64  // target integrated loudness in LUFS
+	SampleFormat      string   // raw sample format ffmpeg sends us, one of the sampleFormats
+	SampleRate        int      // the rate ffmpeg resamples to for the analysis, a multiple of FPS
+	WindowSize        int      // samples analysed each frame, 0 for the power of 2 above the samples per frame
+	Window            string   // the window function, one of the windowFunctions
+	WindowParams      WindowParams
+	// WindowFunction is used instead of the Window if set, for a program
+	// with a window of its own. It is the weight of sample i of s.
+	WindowFunction func(i, s int) float64
+	// MagnitudeGain scales the FFT magnitudes (after dividing by the window
+	// size) into drawing units. The drawn height is then this, times
+	// spectrumHeightMultiplier, raised to the exponent of the spectrum style.
+	// So it is the one place to make the whole visualisation bigger or smaller.
+	MagnitudeGain float64
+
+	// visualisation config
+	Style         string  // one of the style* constants
+	Bands         int     // number of points to draw per spectrum, 0 for every sample
+	SegmentLength float64 // pixels between the points of the spectrum instead of Bands, so bigger rings get more, 0 for none
+	MinHz         float64 // the lowest frequency drawn, 0 for the bottom of the FFT
+	MaxHz         float64 // the highest frequency drawn, 0 for no limit
+	Scale         string  // how the frequencies are spread along the spectrum, one of the scale* constants
+	ReverseFreq   bool    // draw the spectrum treble to bass instead
+	Opacity       float64 // opacity of the spectrums, 1 is solid
+
+	// color adjustments to the finished frame, see Grade
+	Gamma      float64 // more than 1 brightens the midtones
+	Brightness float64 // added to every color, from -1 to 1
+	Contrast   float64 // multiplies the colors' distance from the middle gray
+	Saturation float64 // 0 for gray, 1 unchanged
+	// the glow round the bright parts, see Bloom
+	BloomIntensity float64 // how much glow is added, 0 for none
+	BloomThreshold float64 // how bright (0 to 1) a color has to be to glow
+	BloomRadius    int     // how far the glow spreads, in pixels
+
+	// tweaks to every spectrum style, which preview can tune live
+	HeightScale     float64 // multiplies the height
+	ExponentScale   float64 // multiplies the exponent
+	SmoothingOffset int     // added to the smoothing radius
+
+	SpectrogramScale string // how the frequencies go up the spectrogram, one of the scale* constants
+	ColorMap         string // the spectrogram's colors, one of the colorMaps
+
+	SmoothingKernel   string       // overrides the smoothing kernel of every spectrum style
+	Layout            string       // where the spectrums are drawn, one of the layout* constants
+	Direction         string       // which way the spectrum grows, one of the direction* constants
+	Segments          int          // how many times the (mirrored) spectrum repeats around the circle
+	Symmetry          string       // how the spectrum is mirrored, one of the symmetry* constants
+	LeadIn            bool         // start with the ring at rest, rather than the spectrums appearing one by one
+	History           int          // how many spectrums trail behind the newest, 0 for one per palette color
+	ColorSpace        string       // what the palette is stretched over the History in, one of the colorSpace* constants
+	BPM               float64      // the tempo of the track, for the Beat* automation, 0 for none
+	AutoBPM           bool         // estimate BPM (and BeatOffset, if it's 0) from the audio before rendering
+	BeatOffset        float64      // seconds into the track of the first beat
+	BeatPulse         float64      // how much bigger the radius is on each beat, as a fraction
+	BeatRotate        float64      // degrees the figure turns each beat
+	BeatHue           float64      // degrees the colors turn each beat
+	Interpolation     string       // how the points of the spectrum are joined, one of the interpolations
+	ArcStart          float64      // degrees from the bottom of the circle each (mirrored) half of the spectrum starts
+	ArcSweep          float64      // degrees each (mirrored) half of the spectrum covers
+	ColorMode         string       // how the spectrums are colored, one of the colorMode* constants
+	Palette           string       // the colors of the spectrums, one of the palette* constants
+	Seed              int64        // the seed for the random palette
+	CentroidHue       float64      // degrees the colors turn as the sound gets brighter (by spectral centroid), 0 for none
+	ExponentCurve     [][2]float64 // (position 0-1 along the spectrum, multiplier) points for each style's exponent, empty for 1 everywhere
+	Clamp             string       // how the amplitude is limited, one of the clamp* constants
+	MaxAmplitude      float64      // the limit, as a fraction of half the shorter side of the frame
+	Baseline          float64      // the least height of the spectrum, even in silence, as a fraction of the radius
+	CompressThreshold float64      // where the (soft knee) compressor starts, as a fraction of the clamp's limit
+	CompressRatio     float64      // how much the compressor squashes the amplitude over the threshold, 1 for none
+	BackgroundReact   string       // what the background color pulses with, one of the react* constants
+	BackgroundFrom    color.RGBA   // the background color when it is quiet
+	BackgroundTo      color.RGBA   // the background color when it is loud
+	PeakHold          float64      // the fraction the held peaks fall each frame, 0 for no peak hold line
+	Attack            float64      // seconds for a band to rise most (1-1/e) of the way to a louder level, 0 for at once
+	Release           float64      // seconds for a band to fall most of the way to a quieter level, 0 for at once
+	StrokeWidth       float64      // the width of the outline round each spectrum, 0 for none
+	StrokeColor       color.RGBA   // the color of the outline
+	DrawMode          string       // how each spectrum is drawn, one of the draw* constants
+	LineWidth         float64      // the width of the line for drawLine
+	LineCap           string       // the ends of the line for drawLine, one of the lineCaps
+	CenterColor       color.RGBA   // the circle in the middle
+	CenterRing        float64      // draw the circle as a ring this thick, 0 for a solid disc
+	GridLevels        []float64    // reference rings at these fractions of the headroom, none for no rings
+	GridHz            []float64    // reference lines across the spectrum at these frequencies, none for no lines
+	GridColor         color.RGBA   // of the reference lines, which are faint
+	// Stems are drawn instead of the mix's spectrums, each as its own ring
+	// (in the order given) from its own audio. The mix is still the audio.
+	Stems []Stem
+	// Layers are more visualisations drawn over this one, in order.
+	// Only their visual settings are used, the size and so on are ours.
+	Layers []Config
+
+	// watermark config
+	Watermark         string  // path to an image to draw over every frame
+	WatermarkPosition string  // which corner, one of the watermark* constants
+	WatermarkOpacity  float64 // 0 (invisible) to 1 (solid)
+	WatermarkScale    float64 // the width of the watermark as a fraction of the frame width
+
+	// video output config
+	VideoFile            string
+	Format               string   // the ffmpeg muxer (e.g. matroska), empty to go by the VideoFile extension
+	DataFile             string   // where to write the per-frame data, if anywhere
+	SpectrogramFile      string   // where to write the spectrogram of the whole track, if anywhere
+	Poster               string   // where to write a PNG of a single frame, if anywhere
+	PosterAt             float64  // the time in seconds of the poster frame, -1 for the loudest frame
+	Quiet                bool     // hide ffmpeg's log unless it fails
+	Metadata             []string // key=value tags to set on the output, over those copied from the audio
+	Width                int
+	Height               int
+	FPS                  int  // the analysis rate, frames of audio per FPSDen seconds
+	OutputFPS            int  // the video rate, a multiple of FPS, frames in between are interpolated
+	FPSDen               int  // the denominator of both rates, 1001 for the NTSC rates (30000/1001 is 29.97)
+	MaxFrames            int  // stop after this many (video) frames, 0 for the whole audio
+	Resume               bool // carry on from the end of the outputs of an interrupted render
+	StartFrame           int  // the (video) frame to start at, when resuming
+	VideoCodecAndOptions []string
+	PixelFormat          string // output pixel format (e.g. yuv420p10le for 10bit), empty for the codec default (the flags default h264 to yuv420p)
+	CodecProfile         string // output codec profile (e.g. high10 for 10bit h264), empty for the codec default
+	Bitrate              string // target video bitrate (e.g. 4M) for a two pass encode, empty for one pass
+	Pass                 int    // which pass of a two pass encode this is, 0 for one pass
+	PassLogFile          string // where ffmpeg keeps the first pass stats for the second
+	AudioCodecAndOptions []string
+	ExtraOutputOptions   []string // passed to ffmpeg as they are just before the output, so they override ours
+	SafeAudio            bool     // check the audio can be copied before we start, and transcode it if not
+	Transparent          bool     // no background, for an output with an alpha channel
+
+	// ThreadQueueSize is how many packets of each input ffmpeg will queue.
+	// Too few and it warns that the "thread queue is blocking", but a
+	// packet of our video is a whole raw frame (3.5MB at 720p) so the
+	// memory use can add up when it is full.
+	ThreadQueueSize int
+}
+
+var (
+	// default video will be 720p30
+	defaultWidth  = 1280
+	defaultHeight = 720
+	// named sizes for -resolution
+	resolutions = map[string][2]int{
+		"720p":     {1280, 720},
+		"1080p":    {1920, 1080},
+		"1440p":    {2560, 1440},
+		"4k":       {3840, 2160},
+		"square":   {1080, 1080},
+		"vertical": {1080, 1920}, // for stories/reels
+	}
+	defaultFPS = 30
+	// enough for ffmpeg not to block on the inputs at 4k60
+	defaultThreadQueueSize = 128
+	// the magnitude gain the spectrum styles were tuned with
+	defaultMagnitudeGain = 100.0
+	// default codec options, 264 is simple enough
+	defaultQuality      = "balanced"
+	bitrateVideoOptions = []string{"libx264", "-preset", "medium"} // lossless makes no sense with a target
+	// the '-quality' presets, a slower preset makes a smaller file for the
+	// same crf, and a lower crf is better quality.
+	qualityPresets = map[string][]string{
+		"fast":     {"libx264", "-preset", "ultrafast", "-crf", "23"},
+		"balanced": {"libx264", "-preset", "medium", "-crf", "20"},
+		"high":     {"libx264", "-preset", "slow", "-crf", "18"},
+		"lossless": {"libx264", "-preset", "ultrafast", "-crf", "0"}, // huge, but exactly the frames
+	}
+	// h264 has no alpha channel, ProRes 4444 is what editors expect for overlays
+	transparentVideoOptions = []string{"prores_ks", "-profile:v", "4444"}
+	transparentPixelFormat  = "yuva444p10le"
+	defaultAudioOptions     = []string{"copy"} // keep whatever the original was
+)
+
+// StdoutFile as the VideoFile writes the video to stdout, so
+// everything else we (and ffmpeg) print has to go to stderr.
+const StdoutFile = "-"
+
+// DefaultConfig finds ffmpeg and sets the defaults, the flags fill in the rest.
+// The path to ffmpeg is empty if it isn't there, see ErrNoFFMpeg.
+func DefaultConfig() *Config {
+	ffmpeg, ffprobe, ffplay := findTools()
+	return &Config{
+		FFMpegPath:           ffmpeg,
+		FFProbePath:          ffprobe,
+		FFPlayPath:           ffplay,
+		FPS:                  defaultFPS,
+		MagnitudeGain:        defaultMagnitudeGain,
+		OutputFPS:            defaultFPS,
+		FPSDen:               1,
+		SampleRate:           samplingRate,
+		ThreadQueueSize:      defaultThreadQueueSize,
+		Width:                defaultWidth,
+		Height:               defaultHeight,
+		VideoCodecAndOptions: qualityPresets[defaultQuality],
+		AudioCodecAndOptions: defaultAudioOptions,
+	}
+}
+
+// warnNoFFProbe is so batch only warns about ffprobe once
+var warnNoFFProbe sync.Once
+
+// ErrNoFFMpeg is reading audio without ffmpeg
+var ErrNoFFMpeg = errors.New("Can't find ffmpeg in path")
+
+// findTools finds the ffmpeg binaries in the path. We can't read audio
+// without ffmpeg itself, but a Renderer doesn't need it, so like the others
+// (some installs don't have them) its path is empty if it is missing and
+// the features that need it fail, or are skipped.
+func findTools() (ffmpeg, ffprobe, ffplay string) {
+	ffmpeg, _ = exec.LookPath("ffmpeg")
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		warnNoFFProbe.Do(func() {
+			log.Println("Can't find ffprobe in path, so the audio isn't checked before copying it, is analysed at 44100Hz and '-resume' won't work")
+		})
+	}
+	// only needed to preview, which checks for it
+	ffplay, _ = exec.LookPath("ffplay")
+	return ffmpeg, ffprobe, ffplay
+}
+
+// frameRate is the analysis rate in frames per second
+func frameRate(c *Config) float64 {
+	return float64(c.FPS) / float64(c.FPSDen)
+}
+
+// OutputFrameRate is the video rate in frames per second
+func OutputFrameRate(c *Config) float64 {
+	return float64(c.OutputFPS) / float64(c.FPSDen)
+}
@@ -0,0 +1,109 @@
+package vis
+
+import (
+	"context"
+	"io"
+	"math"
+)
+
+// Crossfade plays two AudioSources one after the other, like a DJ mix.
+// The second source starts at the beginning of the crossfade and for the
+// length of the crossfade the frames are a blend of the two.
+// Any audio left in the first source after the crossfade is dropped.
+type Crossfade struct {
+	a, b     *AudioSource
+	start    int // frame the crossfade starts on
+	duration int // frames the crossfade lasts
+}
+
+// NewCrossfade creates the crossfade from the config start and duration.
+func NewCrossfade(c *Config, a, b *AudioSource) *Crossfade {
+	return &Crossfade{
+		a:        a,
+		b:        b,
+		start:    int(math.Round(c.CrossfadeStart * frameRate(c))),
+		duration: int(math.Max(1, math.Round(c.CrossfadeDuration*frameRate(c)))),
+	}
+}
+
+// StartProcessing reads both sources, handing the blended frames to onFrame
+// in the same way as AudioSource.StartProcessing.
+func (cf *Crossfade) StartProcessing(ctx context.Context, onFrame func(af *AudioFrame) error) error {
+	fa, fb := cf.a.NewFrame(), cf.b.NewFrame()
+	mixed := cf.a.NewFrame()
+	end := cf.start + cf.duration
+	aDone := false
+
+	for i := 0; ; i++ {
+		if ctx.Err() != nil {
+			cf.a.Stop()
+			cf.b.Stop()
+			return ctx.Err()
+		}
+		if i < end && !aDone {
+			if err := cf.a.ReadFrame(fa); err == io.EOF {
+				// the first track was shorter than the crossfade
+				// so it is just silence from here on.
+				aDone = true
+			} else if err != nil {
+				cf.a.Stop()
+				cf.b.Stop()
+				return err
+			}
+		}
+		if aDone || i >= end {
+			clearFrame(fa)
+		}
+		if i >= cf.start {
+			if err := cf.b.ReadFrame(fb); err == io.EOF {
+				// the second track is done, so are we.
+				break
+			} else if err != nil {
+				cf.a.Stop()
+				cf.b.Stop()
+				return err
+			}
+		}
+		// how far through the crossfade we are
+		w := math.Min(1, math.Max(0, float64(i-cf.start)/float64(cf.duration)))
+		lerpFrame(mixed, fa, fb, w)
+		if err := onFrame(mixed); err != nil {
+			cf.a.Stop()
+			cf.b.Stop()
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		// the tracks ended because ffmpeg was killed
+		cf.a.Stop()
+		cf.b.Stop()
+		return ctx.Err()
+	}
+	// we never want the rest of the first track
+	if aDone {
+		if err := cf.a.Wait(); err != nil {
+			cf.b.Wait()
+			return err
+		}
+	} else {
+		cf.a.Stop()
+	}
+	return cf.b.Wait()
+}
+
+// clearFrame turns the frame into silence
+func clearFrame(af *AudioFrame) {
+	for i := range af.data {
+		af.data[i] = 0
+		af.freq[i] = 0
+	}
+	af.centroid = 0
+	af.rms = 0
+	for _, ch := range af.stereo {
+		clearFrame(ch)
+	}
+	for _, stem := range af.stems {
+		clearFrame(stem)
+	}
+}
@@ -0,0 +1,93 @@
+package vis
+
+import (
+	"context"
+	"math"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// testProcess is a finished "ffmpeg" for a test AudioSource to wait for
+// or kill: the test binary, running no tests.
+func testProcess(t *testing.T, as *AudioSource) {
+	t.Helper()
+	as.Cmd = exec.Command(os.Args[0], "-test.run=^$")
+	as.stderr = NewTailBuffer(stderrTailSize)
+	if err := as.Cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// constant is seconds of samples all at level
+func constant(c *Config, level, seconds float64) []float64 {
+	samples := make([]float64, int(seconds*float64(c.SampleRate)))
+	for i := range samples {
+		samples[i] = level
+	}
+	return samples
+}
+
+// crossfadeRMS is the RMS of every frame of the crossfade
+func crossfadeRMS(t *testing.T, c *Config, a, b []float64) []float64 {
+	t.Helper()
+	as, bs := testAudioSource(t, c, a), testAudioSource(t, c, b)
+	testProcess(t, as)
+	testProcess(t, bs)
+	var rms []float64
+	err := NewCrossfade(c, as, bs).StartProcessing(context.Background(), func(af *AudioFrame) error {
+		rms = append(rms, af.RMS())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StartProcessing: %v", err)
+	}
+	return rms
+}
+
+// crossfadeConfig crossfades at 1s for 0.5s, with a window smaller than a
+// frame so each frame's RMS is just its own samples'
+func crossfadeConfig(t *testing.T) *Config {
+	c, err := NewConfig("-window-size", "1024")
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	c.CrossfadeStart, c.CrossfadeDuration = 1, 0.5
+	return c
+}
+
+func TestCrossfade(t *testing.T) {
+	c := crossfadeConfig(t)
+	rms := crossfadeRMS(t, c, constant(c, 0.5, 2), constant(c, 0.1, 1))
+	// the first track up to 1s, then the second's whole second
+	if len(rms) != 60 {
+		t.Fatalf("%d frames, want 60", len(rms))
+	}
+	for i, got := range rms {
+		want := 0.5
+		if i >= 30 {
+			// 15 frames from one to the other
+			w := math.Min(1, float64(i-30)/15)
+			want = 0.5 + (0.1-0.5)*w
+		}
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("frame %d has RMS %g, want %g", i, got, want)
+		}
+	}
+}
+
+func TestCrossfadeShortFirstTrack(t *testing.T) {
+	c := crossfadeConfig(t)
+	// the first track runs out 6 frames into the crossfade
+	rms := crossfadeRMS(t, c, constant(c, 0.5, 1.2), constant(c, 0.1, 1))
+	if len(rms) != 60 {
+		t.Fatalf("%d frames, want 60", len(rms))
+	}
+	for i := 36; i < 45; i++ {
+		// silence to the second track
+		want := 0.1 * float64(i-30) / 15
+		if math.Abs(rms[i]-want) > 1e-9 {
+			t.Errorf("frame %d has RMS %g, want %g", i, rms[i], want)
+		}
+	}
+}
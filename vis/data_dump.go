@@ -0,0 +1,58 @@
+package vis
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// DataDump writes the per-frame spectrum data to a file as newline
+// delimited JSON, one object per frame, so it can drive another renderer.
+//
+//	{"frame":0,"bands":[0.1,0.2,...]}
+type DataDump struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// dumpFrame is the shape of each line in the dump
+type dumpFrame struct {
+	Frame int       `json:"frame"`
+	Bands []float64 `json:"bands"`
+}
+
+// NewDataDump creates (or truncates) the file at path for writing.
+func NewDataDump(path string) (*DataDump, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &DataDump{f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// AppendDataDump opens the file at path to add more frames to the end.
+func AppendDataDump(path string) (*DataDump, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &DataDump{f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// WriteFrame writes a single line for the frame.
+// the json.Encoder adds the newline for us.
+func (dd *DataDump) WriteFrame(frame int, bands []float64) error {
+	return dd.enc.Encode(dumpFrame{Frame: frame, Bands: bands})
+}
+
+// Close flushes any buffered frames and closes the file
+func (dd *DataDump) Close() error {
+	if err := dd.w.Flush(); err != nil {
+		dd.f.Close()
+		return err
+	}
+	return dd.f.Close()
+}
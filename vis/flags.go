@@ -0,0 +1,862 @@
+package vis
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The flags are split into groups, so each command only has the ones
+// that mean something to it. Each group adds itself to the command's
+// FlagSet and once parsed, checks the values and fills in the Config.
+
+// AudioFlags are for reading and analysing the audio, every command has these
+type AudioFlags struct {
+	infile            *string
+	infile2           *string
+	inputOptions      *string
+	crossfadeStart    *float64
+	crossfadeDuration *float64
+	loudnorm          *bool
+	loudnormTarget    *float64
+	pcmFormat         *string
+	nativeRate        *bool
+	windowSize        *int
+	window            *string
+	kaiserBeta        *float64
+	gain              *float64
+	fps               *string
+	optional          bool // no '-audio' is fine, for a Renderer
+}
+
+// AddAudioFlags adds the audio flags to a command's flag set
+func AddAudioFlags(fs *flag.FlagSet) *AudioFlags {
+	return &AudioFlags{
+		infile:            fs.String("audio", "", "The path (or http(s) URL) to an audio file for input"),
+		infile2:           fs.String("audio2", "", "The path (or http(s) URL) to a second audio file to crossfade into"),
+		inputOptions:      fs.String("input-options", "", "Extra ffmpeg options for the audio input(s), put before the '-i', e.g. '-f s16le -ar 48000 -ac 2' for raw PCM"),
+		crossfadeStart:    fs.Float64("crossfade-start", 0, "The time in seconds into '-audio' to start the crossfade into '-audio2'"),
+		crossfadeDuration: fs.Float64("crossfade-duration", 5, "The length in seconds of the crossfade into '-audio2'"),
+		loudnorm:          fs.Bool("loudnorm", false, "Normalise the loudness of the audio before analysis (the output audio is untouched)"),
+		loudnormTarget:    fs.Float64("loudnorm-target", -14, "The target integrated loudness in LUFS for '-loudnorm'"),
+		pcmFormat:         fs.String("sample-format", "f64be", "The raw sample format to decode the audio to: 'f64be', 'f32le', 's24le' or 's16le' (smaller is faster)"),
+		nativeRate:        fs.Bool("native-rate", true, "Analyse the audio at its own sample rate, instead of resampling it to 44100"),
+		windowSize:        fs.Int("window-size", 0, "The number of samples analysed each frame, a power of 2 is fastest (default the power of 2 above the samples per frame)"),
+		window:            fs.String("window", "hamming", "The window function for the frequency analysis: 'rectangle', 'hamming', 'hann' or 'kaiser'"),
+		kaiserBeta:        fs.Float64("kaiser-beta", 8.6, "The beta parameter for the 'kaiser' window, larger gives less leakage between frequencies but blurs them more"),
+		gain:              fs.Float64("gain", defaultMagnitudeGain, "The scale of the frequency magnitudes, larger makes everything bigger"),
+		fps:               fs.String("fps", strconv.Itoa(defaultFPS), "The number of audio frames to analyse per second, a whole number or an NTSC rate like '29.97' or '30000/1001'"),
+	}
+}
+
+// Apply the audio flags to the config, first of the flags.
+func (f *AudioFlags) Apply(c *Config) error {
+	if *f.infile == "" && !f.optional {
+		return errors.New("Must provide an audio input file '-audio'")
+	}
+	for _, in := range []string{*f.infile, *f.infile2} {
+		if in == "" || isURL(in) {
+			// ffmpeg will tell us if a URL is no good
+			continue
+		}
+		if _, err := os.Stat(in); err != nil {
+			return fmt.Errorf("Can't read the audio input: %s", err)
+		}
+	}
+	if *f.loudnormTarget < -70 || *f.loudnormTarget > -5 {
+		return errors.New("Loudness target must be between -70 and -5 LUFS '-loudnorm-target'")
+	}
+	fps, den, err := parseFrameRate(*f.fps)
+	if err != nil {
+		return fmt.Errorf("Bad frame rate '-fps %s': %s", *f.fps, err)
+	}
+	if *f.infile2 != "" && (*f.crossfadeStart <= 0 || *f.crossfadeDuration <= 0) {
+		return errors.New("Must provide a crossfade start and duration '-crossfade-start', '-crossfade-duration' with '-audio2'")
+	}
+	if _, ok := sampleFormats[*f.pcmFormat]; !ok {
+		return fmt.Errorf("Unknown sample format '-sample-format %s'", *f.pcmFormat)
+	}
+	if *f.windowSize < 0 || *f.windowSize == 1 {
+		return errors.New("Window size must be at least 2 '-window-size'")
+	}
+	if *f.gain <= 0 {
+		return errors.New("Gain must be more than 0 '-gain'")
+	}
+	if _, ok := windowFunctions[*f.window]; !ok {
+		return fmt.Errorf("Unknown window function '-window %s'", *f.window)
+	}
+	c.AudioFile = *f.infile
+	c.AudioFile2 = *f.infile2
+	c.InputOptions = strings.Fields(*f.inputOptions)
+	c.CrossfadeStart = *f.crossfadeStart
+	c.CrossfadeDuration = *f.crossfadeDuration
+	c.LoudNorm = *f.loudnorm
+	c.LoudNormTarget = *f.loudnormTarget
+	c.SampleFormat = *f.pcmFormat
+	c.WindowSize = *f.windowSize
+	c.Window = *f.window
+	c.WindowParams = WindowParams{KaiserBeta: *f.kaiserBeta}
+	c.MagnitudeGain = *f.gain
+	c.FPS = fps
+	c.OutputFPS = fps
+	c.FPSDen = den
+	if *f.nativeRate && c.AudioFile != "" {
+		c.SampleRate = nativeSampleRate(c)
+	}
+	return nil
+}
+
+// isURL is true for the network inputs we hand straight to ffmpeg
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// VisualFlags are for drawing the frames, for the commands that draw
+type VisualFlags struct {
+	fs                *flag.FlagSet // to see which were set
+	style             *string
+	bands             *int
+	segmentLength     *float64
+	minHz             *float64
+	maxHz             *float64
+	scale             *string
+	reverseFreq       *bool
+	opacity           *float64
+	gamma             *float64
+	brightness        *float64
+	contrast          *float64
+	saturation        *float64
+	bloom             *float64
+	bloomThreshold    *float64
+	bloomRadius       *int
+	heightScale       *float64
+	exponentScale     *float64
+	smoothingOffset   *int
+	smoothingKernel   *string
+	direction         *string
+	segments          *int
+	symmetry          *string
+	leadIn            *bool
+	history           *int
+	colorSpace        *string
+	bpm               *string
+	beatOffset        *float64
+	beatPulse         *float64
+	beatRotate        *float64
+	beatHue           *float64
+	layout            *string
+	interpolation     *string
+	arcStart          *float64
+	arcSweep          *float64
+	colorMode         *string
+	spectrogramScale  *string
+	colorMap          *string
+	palette           *string
+	seed              *int64
+	centroidHue       *float64
+	exponentCurve     *string
+	backgroundReact   *string
+	backgroundFrom    *string
+	backgroundTo      *string
+	clamp             *string
+	compressThreshold *float64
+	compressRatio     *float64
+	maxAmplitude      *float64
+	baseline          *float64
+	peakHold          *float64
+	attack            *float64
+	release           *float64
+	strokeWidth       *float64
+	strokeColor       *string
+	drawMode          *string
+	lineWidth         *float64
+	lineCap           *string
+	centerColor       *string
+	centerRing        *float64
+	gridLevels        *string
+	gridHz            *string
+	gridColor         *string
+	watermark         *string
+	watermarkPosition *string
+	watermarkOpacity  *float64
+	watermarkScale    *float64
+	resolution        *string
+	width             *int
+	height            *int
+	fpsOut            *string
+	layers            *stringList
+	stems             *stringList
+	maxFrames         *int
+	threadQueueSize   *int
+}
+
+// AddVisualFlags adds the visual flags to a command's flag set
+func AddVisualFlags(fs *flag.FlagSet) *VisualFlags {
+	f := &VisualFlags{
+		fs:                fs,
+		style:             fs.String("style", styleSpectrum, "The visualisation style: 'spectrum', 'waveform' or 'spectrogram' (scrolling across the frame)"),
+		spectrogramScale:  fs.String("spectrogram-scale", scaleLog, "How the frequencies go up the spectrogram: 'log' (each octave the same height), 'mel' or 'linear'"),
+		colorMap:          fs.String("colormap", "heat", "The colors of the spectrogram from quiet to loud: 'heat', 'gray' or 'rainbow'"),
+		minHz:             fs.Float64("min-hz", 0, "The lowest frequency to draw (e.g. 40)"),
+		maxHz:             fs.Float64("max-hz", 0, "The highest frequency to draw (e.g. 16000), 0 for no limit"),
+		scale:             fs.String("scale", scaleLinear, "How the frequencies are spread along the spectrum: 'linear' (the FFT bins as they are), 'log' (each octave the same length) or 'mel' (even steps in pitch)"),
+		reverseFreq:       fs.Bool("reverse-freq", false, "Draw the spectrum treble to bass, so the high frequencies are at the start of the arc"),
+		bands:             fs.Int("bands", 0, "The number of points to draw per spectrum (64-256 looks good), 0 to draw every one"),
+		segmentLength:     fs.Float64("segment-length", 0, "Draw the spectrum with a point every this many pixels (e.g. 6) instead of '-bands', so it looks as smooth at any size, 0 for none"),
+		opacity:           fs.Float64("opacity", 1, "The opacity of the spectrums from 0 to 1, less than 1 lets older spectrums show through"),
+		gamma:             fs.Float64("gamma", 1, "Adjust the gamma of the finished frame, more than 1 brightens the midtones"),
+		brightness:        fs.Float64("brightness", 0, "Add to the brightness of the finished frame, from -1 to 1"),
+		contrast:          fs.Float64("contrast", 1, "Multiply the contrast of the finished frame, 1 for unchanged"),
+		saturation:        fs.Float64("saturation", 1, "Multiply the saturation of the finished frame, 0 for black and white"),
+		bloom:             fs.Float64("bloom", 0, "Make the bright parts glow by this much (e.g. 0.8), 0 for none. It is slow, especially with a big '-bloom-radius'"),
+		bloomThreshold:    fs.Float64("bloom-threshold", 0.6, "How bright (0 to 1) a color has to be to glow with '-bloom'"),
+		bloomRadius:       fs.Int("bloom-radius", 12, "How far in pixels the '-bloom' glow spreads"),
+		heightScale:       fs.Float64("height-scale", 1, "Multiply the height of every spectrum (preview can tune this live)"),
+		exponentScale:     fs.Float64("exponent-scale", 1, "Multiply the exponent of every spectrum, more makes the peaks stand out (preview can tune this live)"),
+		smoothingOffset:   fs.Int("smoothing-offset", 0, "Add to the smoothing radius of every spectrum, less is spikier (preview can tune this live)"),
+		smoothingKernel:   fs.String("smoothing-kernel", "", "Use this smoothing kernel for every spectrum: 'box', 'triangle' or 'gaussian' (default per spectrum)"),
+		direction:         fs.String("direction", directionOutward, "Which way the spectrum grows from the circle: 'outward', 'inward' or 'both'"),
+		interpolation:     fs.String("interpolation", "quad", "How to join the points of the spectrum: 'linear' (sharp), 'quad' or 'cubic' (smoothest)"),
+		layout:            fs.String("layout", layoutCircular, "Where to draw the spectrums: 'circular', 'linear' (mirrored either side of the middle, across the frame) or 'stereo-split' (a circle, with the left channel on the left and the right on the right)"),
+		segments:          fs.Int("segments", 1, "The number of times to repeat the (mirrored) spectrum around the circle, like a kaleidoscope"),
+		symmetry:          fs.String("symmetry", symmetryHorizontal, "How to mirror the spectrum: 'horizontal' (left/right), 'vertical' (top/bottom) or 'both' (four ways, like a mandala)"),
+		leadIn:            fs.Bool("leadin", false, "Start with the ring at rest, as if there had been silence before the track, rather than the spectrums popping in over the first few frames"),
+		history:           fs.Int("history", 0, "The number of spectrums in the trail, the palette is stretched into a gradient to color them (default 8, one per color)"),
+		colorSpace:        fs.String("color-space", colorSpaceHSV, "How the palette colors are mixed for a longer '-history': 'hsv' (vivid), 'lab' (even to the eye) or 'rgb' (can be muddy)"),
+		bpm:               fs.String("bpm", "0", "The tempo of the track, to move with the beat using '-beat-pulse', '-beat-rotate' and '-beat-hue' (whatever the audio does), or 'auto' to estimate it (and the '-beat-offset') first"),
+		beatOffset:        fs.Float64("beat-offset", 0, "The time in seconds of the first beat for '-bpm'"),
+		beatPulse:         fs.Float64("beat-pulse", 0, "Grow the circle by this fraction on each '-bpm' beat, easing back in between (e.g. 0.05)"),
+		beatRotate:        fs.Float64("beat-rotate", 0, "Turn the whole figure by this many degrees each '-bpm' beat"),
+		beatHue:           fs.Float64("beat-hue", 0, "Turn the colors round the color wheel by this many degrees each '-bpm' beat"),
+		arcStart:          fs.Float64("arc-start", 0, "The angle in degrees from the bottom of the circle where each (mirrored) half of the spectrum starts"),
+		arcSweep:          fs.Float64("arc-sweep", 180, "The angle in degrees each (mirrored) half of the spectrum covers"),
+		palette:           fs.String("palette", paletteDefault, "The colors of the spectrums: 'default' or 'random' (from '-seed')"),
+		seed:              fs.Int64("seed", 0, "The seed for '-palette random', the same seed gives the same colors (default a new one each run, which is logged)"),
+		centroidHue:       fs.Float64("centroid-hue", 0, "Turn the colors round the color wheel by up to this many degrees as the sound gets brighter (e.g. 90)"),
+		exponentCurve:     fs.String("exponent-curve", "", "Shape how much each part of the spectrum reacts, as 'position:multiplier' points (positions 0 bass to 1 treble) for the exponents, e.g. '0:0.9,1:1.2'"),
+		backgroundReact:   fs.String("background-react", reactNone, "Pulse the background color with the 'loudness' or the 'bass', or 'none' for black"),
+		backgroundFrom:    fs.String("background-from", "#000000", "The '-background-react' color when it is quiet"),
+		backgroundTo:      fs.String("background-to", "#302050", "The '-background-react' color when it is loud"),
+		colorMode:         fs.String("color-mode", colorModeAge, "How to color the spectrums: 'age' (each has its own color) or 'frequency' (a rainbow from bass to treble)"),
+		clamp:             fs.String("clamp", clampNone, "How to stop loud peaks going off the frame: 'none', 'hard' (flatten them) or 'soft' (compress them)"),
+		compressThreshold: fs.Float64("compress-threshold", 0.6, "Where the compressor starts, as a fraction of '-max-amplitude' (past the circle)"),
+		compressRatio:     fs.Float64("compress-ratio", 1.5, "How much the compressor squashes the spectrum above '-compress-threshold', 1 for no compression"),
+		maxAmplitude:      fs.Float64("max-amplitude", 1, "The furthest the spectrum reaches with '-clamp', as a fraction of half the shorter side of the frame"),
+		baseline:          fs.Float64("baseline", 0, "The least height of the spectrum, as a fraction of the circle's radius, so silence still shows a ring (e.g. 0.05)"),
+		peakHold:          fs.Float64("peak-hold", 0, "Draw a line at the recent peaks, which falls by this fraction each frame (0.05 is good), 0 for no line"),
+		attack:            fs.Float64("attack", 0, "The time in seconds each band takes to rise to a louder level, 0 for at once"),
+		release:           fs.Float64("release", 0, "The time in seconds each band takes to fall to a quieter level, longer than '-attack' looks like a meter (e.g. 0.01 and 0.15)"),
+		strokeWidth:       fs.Float64("stroke-width", 0, "Outline each spectrum with a line this many pixels wide, 0 for no outline"),
+		strokeColor:       fs.String("stroke-color", "#000000", "The color of the '-stroke-width' outline"),
+		drawMode:          fs.String("draw-mode", drawFill, "How to draw each spectrum: 'fill' (the area) or 'line' (just its edge, for line art)"),
+		lineWidth:         fs.Float64("line-width", 2, "The width of the lines for '-draw-mode line'"),
+		centerColor:       fs.String("center-color", "#ffffff", "The color of the circle in the middle"),
+		centerRing:        fs.Float64("center-ring", 0, "Draw the circle in the middle as a ring this many pixels thick, so it is hollow, 0 for a solid disc"),
+		gridLevels:        fs.String("grid-levels", "", "Draw faint reference rings at these heights, as fractions of how far the spectrum can go, e.g. '0.25,0.5,0.75'"),
+		gridHz:            fs.String("grid-hz", "", "Draw faint reference lines across the spectrum at these frequencies, e.g. '100,1000,10000'"),
+		gridColor:         fs.String("grid-color", "#ffffff", "The color of the '-grid-levels' and '-grid-hz' lines"),
+		lineCap:           fs.String("line-cap", "round", "The ends of the lines for '-draw-mode line': 'round', 'butt' or 'square'"),
+		watermark:         fs.String("watermark", "", "The path to an image (png or jpeg) to draw over the corner of every frame"),
+		watermarkPosition: fs.String("watermark-position", watermarkBottomRight, "The corner to draw the '-watermark': 'top-left', 'top-right', 'bottom-left' or 'bottom-right'"),
+		watermarkOpacity:  fs.Float64("watermark-opacity", 0.8, "The opacity of the '-watermark' from 0 to 1"),
+		watermarkScale:    fs.Float64("watermark-scale", 0.15, "The width of the '-watermark' as a fraction of the video width"),
+		resolution:        fs.String("resolution", "", "A named video size: '720p', '1080p', '1440p', '4k', 'square' or 'vertical' (1080x1920)"),
+		width:             fs.Int("width", defaultWidth, "The video width in pixels (overrides '-resolution')"),
+		height:            fs.Int("height", defaultHeight, "The video height in pixels (overrides '-resolution')"),
+		threadQueueSize:   fs.Int("thread-queue-size", defaultThreadQueueSize, "How many frames ffmpeg can queue before it blocks, raise it if ffmpeg warns the thread queue is blocking (each is a raw frame, so it costs memory)"),
+		maxFrames:         fs.Int("max-frames", 0, "Stop after this many video frames, for quick tests (default all of the audio)"),
+		fpsOut:            fs.String("fps-out", "", "The video frame rate, a multiple of '-fps' with the frames between interpolated, e.g. '60' or '59.94' (default same as '-fps')"),
+		layers:            &stringList{},
+		stems:             &stringList{},
+	}
+	fs.Var(f.stems, "stem", "Draw a stem of the track (e.g. the drums) as its own ring instead of the mix's spectrums, as 'name=path color=#rrggbb' (can be repeated, the color is optional)")
+	fs.Var(f.layers, "layer", "Draw another visualisation over this one, with its own visual flags, e.g. '-style waveform -layout linear' (can be repeated, drawn in order)")
+	return f
+}
+
+// Apply the visual flags, after the audio flags as we need the FPS.
+func (f *VisualFlags) Apply(c *Config) error {
+	if *f.style != styleSpectrum && *f.style != styleWaveform && *f.style != styleSpectrogram {
+		return fmt.Errorf("Unknown style '-style %s'", *f.style)
+	}
+	if _, ok := frequencyScales[*f.spectrogramScale]; !ok {
+		return fmt.Errorf("Unknown spectrogram scale '-spectrogram-scale %s'", *f.spectrogramScale)
+	}
+	if _, ok := frequencyScales[*f.scale]; !ok {
+		return fmt.Errorf("Unknown frequency scale '-scale %s'", *f.scale)
+	}
+	if _, ok := colorMaps[*f.colorMap]; !ok {
+		return fmt.Errorf("Unknown color map '-colormap %s'", *f.colorMap)
+	}
+	if *f.minHz < 0 || *f.maxHz < 0 || *f.maxHz > float64(c.SampleRate/2) || (*f.maxHz > 0 && *f.maxHz <= *f.minHz) {
+		return fmt.Errorf("Frequency range must be within 0-%dHz '-min-hz', '-max-hz'", c.SampleRate/2)
+	}
+	if *f.bands < 0 || *f.bands == 1 {
+		return errors.New("Must have at least 2 bands '-bands'")
+	}
+	if *f.segmentLength < 0 || (*f.segmentLength > 0 && *f.bands > 0) {
+		return errors.New("Segment length must be more than 0, and not with '-bands' '-segment-length'")
+	}
+	if *f.gamma <= 0 || *f.contrast < 0 || *f.saturation < 0 || *f.brightness < -1 || *f.brightness > 1 {
+		return errors.New("Gamma must be more than 0, contrast and saturation 0 or more, and brightness from -1 to 1 '-gamma', '-contrast', '-saturation', '-brightness'")
+	}
+	if *f.bloom < 0 || *f.bloomThreshold < 0 || *f.bloomThreshold >= 1 || *f.bloomRadius < 1 {
+		return errors.New("Bloom must be 0 or more, its threshold from 0 to less than 1 and its radius at least 1 '-bloom', '-bloom-threshold', '-bloom-radius'")
+	}
+	if *f.heightScale <= 0 || *f.exponentScale <= 0 {
+		return errors.New("Height and exponent scales must be more than 0 '-height-scale', '-exponent-scale'")
+	}
+	if *f.opacity < 0 || *f.opacity > 1 {
+		return errors.New("Opacity must be between 0 and 1 '-opacity'")
+	}
+	if *f.threadQueueSize < 1 {
+		return errors.New("Thread queue size must be at least 1 '-thread-queue-size'")
+	}
+	if *f.maxFrames < 0 {
+		return errors.New("Max frames can't be negative '-max-frames'")
+	}
+	fpsOut, den := c.FPS, c.FPSDen
+	if *f.fpsOut != "" {
+		var err error
+		if fpsOut, den, err = parseFrameRate(*f.fpsOut); err != nil {
+			return fmt.Errorf("Bad frame rate '-fps-out %s': %s", *f.fpsOut, err)
+		}
+	}
+	if den != c.FPSDen || fpsOut < c.FPS || fpsOut%c.FPS != 0 {
+		return errors.New("Output FPS must be a multiple of the analysis FPS '-fps-out'")
+	}
+	if _, ok := smoothingKernels[*f.smoothingKernel]; *f.smoothingKernel != "" && !ok {
+		return fmt.Errorf("Unknown smoothing kernel '-smoothing-kernel %s'", *f.smoothingKernel)
+	}
+	switch *f.direction {
+	case directionOutward, directionInward, directionBoth:
+	default:
+		return fmt.Errorf("Unknown direction '-direction %s'", *f.direction)
+	}
+	switch *f.symmetry {
+	case symmetryHorizontal, symmetryVertical, symmetryBoth:
+	default:
+		return fmt.Errorf("Unknown symmetry '-symmetry %s'", *f.symmetry)
+	}
+	if *f.history < 0 {
+		return errors.New("History can't be negative '-history'")
+	}
+	switch *f.colorSpace {
+	case colorSpaceRGB, colorSpaceHSV, colorSpaceLab:
+	default:
+		return fmt.Errorf("Unknown color space '-color-space %s'", *f.colorSpace)
+	}
+	// the tempo is estimated by the command, it needs the audio
+	bpm, beatOffset, autoBPM := 0.0, *f.beatOffset, *f.bpm == "auto"
+	if autoBPM {
+		if *f.beatPulse == 0 && *f.beatRotate == 0 && *f.beatHue == 0 {
+			return errors.New("Nothing moves with the beat, so there's no need to find the tempo '-bpm auto'")
+		}
+	} else if v, err := strconv.ParseFloat(*f.bpm, 64); err != nil {
+		return fmt.Errorf("Tempo must be a number or 'auto' '-bpm %s'", *f.bpm)
+	} else {
+		bpm = v
+	}
+	if bpm < 0 || beatOffset < 0 || *f.beatPulse < 0 {
+		return errors.New("Tempo, beat offset and pulse can't be negative '-bpm', '-beat-offset', '-beat-pulse'")
+	}
+	if bpm == 0 && !autoBPM && (*f.beatPulse != 0 || *f.beatRotate != 0 || *f.beatHue != 0) {
+		return errors.New("Must provide the tempo '-bpm' to move with the beat")
+	}
+	if *f.segments < 1 {
+		return errors.New("Must have at least 1 segment '-segments'")
+	}
+	if *f.watermarkOpacity < 0 || *f.watermarkOpacity > 1 {
+		return errors.New("Watermark opacity must be between 0 and 1 '-watermark-opacity'")
+	}
+	if *f.watermarkScale <= 0 || *f.watermarkScale > 1 {
+		return errors.New("Watermark scale must be between 0 and 1 '-watermark-scale'")
+	}
+	if *f.palette != paletteDefault && *f.palette != paletteRandom {
+		return fmt.Errorf("Unknown palette '-palette %s'", *f.palette)
+	}
+	if *f.palette == paletteRandom && *f.seed == 0 {
+		*f.seed = time.Now().UnixNano()
+		log.Printf("Using palette seed %d", *f.seed)
+	}
+	if *f.layout != layoutCircular && *f.layout != layoutLinear && *f.layout != layoutStereoSplit {
+		return fmt.Errorf("Unknown layout '-layout %s'", *f.layout)
+	}
+	if _, ok := interpolations[*f.interpolation]; !ok {
+		return fmt.Errorf("Unknown interpolation '-interpolation %s'", *f.interpolation)
+	}
+	if *f.colorMode != colorModeAge && *f.colorMode != colorModeFrequency {
+		return fmt.Errorf("Unknown color mode '-color-mode %s'", *f.colorMode)
+	}
+	if *f.resolution != "" {
+		size, ok := resolutions[*f.resolution]
+		if !ok {
+			return fmt.Errorf("Unknown resolution '-resolution %s'", *f.resolution)
+		}
+		// an explicit width or height still wins
+		set := map[string]bool{}
+		f.fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+		if !set["width"] {
+			*f.width = size[0]
+		}
+		if !set["height"] {
+			*f.height = size[1]
+		}
+	}
+	if *f.width <= 0 || *f.height <= 0 || *f.width%2 != 0 || *f.height%2 != 0 {
+		return errors.New("Width and height must be positive and even '-width', '-height'")
+	}
+	switch *f.clamp {
+	case clampNone, clampHard, clampSoft:
+	default:
+		return fmt.Errorf("Unknown clamp '-clamp %s'", *f.clamp)
+	}
+	if *f.maxAmplitude <= 0.5 {
+		// the circle itself is at 0.5
+		return errors.New("Max amplitude must be more than 0.5 '-max-amplitude'")
+	}
+	if *f.baseline < 0 || *f.baseline > 1 {
+		return errors.New("Baseline must be from 0 to 1 '-baseline'")
+	}
+	if *f.drawMode != drawFill && *f.drawMode != drawLine {
+		return fmt.Errorf("Unknown draw mode '-draw-mode %s'", *f.drawMode)
+	}
+	if *f.lineWidth <= 0 {
+		return errors.New("Line width must be more than 0 '-line-width'")
+	}
+	if _, ok := lineCaps[*f.lineCap]; !ok {
+		return fmt.Errorf("Unknown line cap '-line-cap %s'", *f.lineCap)
+	}
+	if *f.compressThreshold <= 0 || *f.compressThreshold > 1 {
+		return errors.New("Compressor threshold must be between 0 and 1 '-compress-threshold'")
+	}
+	if *f.compressRatio < 1 {
+		return errors.New("Compressor ratio must be at least 1 '-compress-ratio'")
+	}
+	if *f.peakHold < 0 || *f.peakHold >= 1 {
+		return errors.New("Peak hold must be between 0 and 1 '-peak-hold'")
+	}
+	if *f.attack < 0 || *f.release < 0 {
+		return errors.New("Attack and release can't be negative '-attack', '-release'")
+	}
+	if *f.strokeWidth < 0 {
+		return errors.New("Stroke width can't be negative '-stroke-width'")
+	}
+	if *f.arcStart < 0 || *f.arcSweep <= 0 || *f.arcStart+*f.arcSweep > 180 {
+		return errors.New("Arc must start at 0 or more degrees and end by 180 '-arc-start', '-arc-sweep'")
+	}
+	c.Style = *f.style
+	c.Bands = *f.bands
+	c.SegmentLength = *f.segmentLength
+	c.MinHz = *f.minHz
+	c.MaxHz = *f.maxHz
+	c.Scale = *f.scale
+	c.ReverseFreq = *f.reverseFreq
+	c.Opacity = *f.opacity
+	c.Gamma = *f.gamma
+	c.Brightness = *f.brightness
+	c.Contrast = *f.contrast
+	c.Saturation = *f.saturation
+	c.BloomIntensity = *f.bloom
+	c.BloomThreshold = *f.bloomThreshold
+	c.BloomRadius = *f.bloomRadius
+	c.HeightScale = *f.heightScale
+	c.ExponentScale = *f.exponentScale
+	c.SmoothingOffset = *f.smoothingOffset
+	c.SmoothingKernel = *f.smoothingKernel
+	c.Direction = *f.direction
+	c.Segments = *f.segments
+	c.Symmetry = *f.symmetry
+	c.LeadIn = *f.leadIn
+	c.History = *f.history
+	c.ColorSpace = *f.colorSpace
+	c.BPM = bpm
+	c.AutoBPM = autoBPM
+	c.BeatOffset = beatOffset
+	c.BeatPulse = *f.beatPulse
+	c.BeatRotate = *f.beatRotate
+	c.BeatHue = *f.beatHue
+	c.Layout = *f.layout
+	c.Interpolation = *f.interpolation
+	c.ArcStart = *f.arcStart
+	c.ArcSweep = *f.arcSweep
+	c.ColorMode = *f.colorMode
+	c.SpectrogramScale = *f.spectrogramScale
+	c.ColorMap = *f.colorMap
+	c.Palette = *f.palette
+	c.Seed = *f.seed
+	c.CentroidHue = *f.centroidHue
+	switch *f.backgroundReact {
+	case reactNone, reactLoudness, reactBass:
+	default:
+		return fmt.Errorf("Unknown background reaction '-background-react %s'", *f.backgroundReact)
+	}
+	var err error
+	if c.BackgroundFrom, err = parseHexColor(*f.backgroundFrom); err != nil {
+		return fmt.Errorf("Bad color '-background-from': %s", err)
+	}
+	if c.BackgroundTo, err = parseHexColor(*f.backgroundTo); err != nil {
+		return fmt.Errorf("Bad color '-background-to': %s", err)
+	}
+	c.BackgroundReact = *f.backgroundReact
+	if c.StrokeColor, err = parseHexColor(*f.strokeColor); err != nil {
+		return fmt.Errorf("Bad color '-stroke-color': %s", err)
+	}
+	c.StrokeWidth = *f.strokeWidth
+	if c.CenterColor, err = parseHexColor(*f.centerColor); err != nil {
+		return fmt.Errorf("Bad color '-center-color': %s", err)
+	}
+	if *f.centerRing < 0 {
+		return errors.New("Center ring must be 0 or more '-center-ring'")
+	}
+	c.CenterRing = *f.centerRing
+	if c.GridLevels, err = parseList(*f.gridLevels); err != nil {
+		return fmt.Errorf("Bad grid levels '-grid-levels': %s", err)
+	}
+	for _, level := range c.GridLevels {
+		if level <= 0 || level > 1 {
+			return errors.New("Grid levels must be more than 0, up to 1 '-grid-levels'")
+		}
+	}
+	if c.GridHz, err = parseList(*f.gridHz); err != nil {
+		return fmt.Errorf("Bad grid frequencies '-grid-hz': %s", err)
+	}
+	for _, hz := range c.GridHz {
+		if hz <= 0 || hz > float64(c.SampleRate/2) {
+			return fmt.Errorf("Grid frequencies must be within 0-%dHz '-grid-hz'", c.SampleRate/2)
+		}
+	}
+	if c.GridColor, err = parseHexColor(*f.gridColor); err != nil {
+		return fmt.Errorf("Bad color '-grid-color': %s", err)
+	}
+	c.DrawMode = *f.drawMode
+	c.LineWidth = *f.lineWidth
+	c.LineCap = *f.lineCap
+	if *f.exponentCurve != "" {
+		curve, err := parseCurve(*f.exponentCurve)
+		if err != nil {
+			return fmt.Errorf("Bad exponent curve '-exponent-curve %s': %s", *f.exponentCurve, err)
+		}
+		c.ExponentCurve = curve
+	}
+	c.Clamp = *f.clamp
+	c.CompressThreshold = *f.compressThreshold
+	c.CompressRatio = *f.compressRatio
+	c.MaxAmplitude = *f.maxAmplitude
+	c.Baseline = *f.baseline
+	c.PeakHold = *f.peakHold
+	c.Attack = *f.attack
+	c.Release = *f.release
+	c.Watermark = *f.watermark
+	c.WatermarkPosition = *f.watermarkPosition
+	c.WatermarkOpacity = *f.watermarkOpacity
+	c.WatermarkScale = *f.watermarkScale
+	c.Width = *f.width
+	c.Height = *f.height
+	c.OutputFPS = fpsOut
+	c.MaxFrames = *f.maxFrames
+	c.ThreadQueueSize = *f.threadQueueSize
+	c.Stems = nil
+	for i, spec := range *f.stems {
+		stem, err := parseStem(spec, i)
+		if err != nil {
+			return fmt.Errorf("Bad stem '-stem %s': %s", spec, err)
+		}
+		if !isURL(stem.File) {
+			if _, err := os.Stat(stem.File); err != nil {
+				return fmt.Errorf("Can't read the stem '-stem %s': %s", spec, err)
+			}
+		}
+		c.Stems = append(c.Stems, stem)
+	}
+	if len(c.Stems) > 0 && c.AudioFile2 != "" {
+		return errors.New("Can't crossfade stems '-stem', '-audio2'")
+	}
+	c.Layers = nil
+	for _, spec := range *f.layers {
+		l, err := layerConfig(c, spec)
+		if err != nil {
+			return err
+		}
+		c.Layers = append(c.Layers, l)
+	}
+	return nil
+}
+
+// layerConfig is the config for a '-layer', the spec is the visual flags
+// for it. They start from the defaults, not the flags of the layer below.
+func layerConfig(c *Config, spec string) (Config, error) {
+	fs := flag.NewFlagSet("layer", flag.ContinueOnError)
+	f := AddVisualFlags(fs)
+	if err := fs.Parse(strings.Fields(spec)); err != nil {
+		return Config{}, fmt.Errorf("Bad layer '-layer %s': %s", spec, err)
+	}
+	drawOnly := true
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "layer", "stem", "resolution", "width", "height", "fps-out", "max-frames", "thread-queue-size",
+			"watermark", "watermark-position", "watermark-opacity", "watermark-scale",
+			"gamma", "brightness", "contrast", "saturation", "bloom", "bloom-threshold", "bloom-radius":
+			drawOnly = false
+		}
+	})
+	if !drawOnly {
+		return Config{}, fmt.Errorf("A layer can only change how it is drawn '-layer %s'", spec)
+	}
+	l := *c
+	if err := f.Apply(&l); err != nil {
+		return Config{}, err
+	}
+	if l.Style == styleSpectrogram {
+		return Config{}, fmt.Errorf("A spectrogram can only be the bottom layer '-layer %s'", spec)
+	}
+	l.Width, l.Height = c.Width, c.Height
+	l.OutputFPS, l.MaxFrames, l.ThreadQueueSize = c.OutputFPS, c.MaxFrames, c.ThreadQueueSize
+	// the layer below shows through
+	l.Transparent = true
+	l.Watermark = ""
+	l.Stems = nil
+	return l, nil
+}
+
+// OutputFlags are for the files we write, only for the commands that render
+type OutputFlags struct {
+	fs           *flag.FlagSet // to see which were set
+	outfile      *string
+	format       *string
+	dumpData     *string
+	spectrogram  *string
+	poster       *string
+	posterAt     *float64
+	pixFmt       *string
+	codecProfile *string
+	bitrate      *string
+	quality      *string
+	resume       *bool
+	transparent  *bool
+	safeAudio    *bool
+	quiet        *bool
+	metadata     *stringList
+	extra        *string
+}
+
+// AddOutputFlags adds the output flags to a command's flag set
+func AddOutputFlags(fs *flag.FlagSet) *OutputFlags {
+	f := &OutputFlags{
+		fs:           fs,
+		outfile:      fs.String("video", "output/output.mkv", "The path to a video file for output, '-' to write it to stdout. Placeholders like '{basename}' are filled in, see the README"),
+		format:       fs.String("format", "", "The output container (ffmpeg muxer), e.g. 'matroska' or 'mp4' (default from the '-video' extension)"),
+		spectrogram:  fs.String("spectrogram-image", "", "The path to save the spectrogram of the whole track as a PNG, a column per frame (with '-style spectrogram')"),
+		dumpData:     fs.String("dump-data", "", "The path to write per-frame spectrum data as NDJSON, use with '-video \"\"' to skip the video"),
+		poster:       fs.String("poster", "", "The path to save a single frame as a PNG, for a thumbnail"),
+		posterAt:     fs.Float64("poster-at", -1, "The time in seconds of the '-poster' frame (default the loudest frame)"),
+		pixFmt:       fs.String("pix-fmt", "", "The output pixel format, e.g. 'yuv420p10le' for 10bit video (default 'yuv420p' for h264/h265 so everything can play it, otherwise the codec's choice)"),
+		codecProfile: fs.String("codec-profile", "", "The output video codec profile, e.g. 'high10' for 10bit h264 (default the codec's choice)"),
+		bitrate:      fs.String("bitrate", "", "A target video bitrate, e.g. '4M', for a two pass encode (renders everything twice), instead of '-quality'"),
+		quality:      fs.String("quality", defaultQuality, "The encoder preset: 'fast', 'balanced', 'high' or 'lossless' (huge files), '-bitrate' and '-transparent' replace it"),
+		resume:       fs.Bool("resume", false, "Carry on from the end of the video and/or data dump of an interrupted render"),
+		transparent:  fs.Bool("transparent", false, "Leave out the background, writing ProRes 4444 with an alpha channel to overlay on other footage (use a .mov or .mkv '-video')"),
+		safeAudio:    fs.Bool("safe-audio", true, "Check the audio can be copied into the output before rendering, and transcode it if it can't"),
+		quiet:        fs.Bool("quiet", false, "Hide ffmpeg's output, it is still shown if something goes wrong"),
+		metadata:     &stringList{},
+		extra:        fs.String("output-options", "", "Extra ffmpeg options for the video output, added last so they override ours, e.g. '-vf eq=saturation=1.2 -movflags +faststart'"),
+	}
+	fs.Var(f.metadata, "metadata", "A 'key=value' tag to set on the output, e.g. 'title=My Song' (can be repeated, the audio file's tags are copied anyway)")
+	return f
+}
+
+// Apply the output flags, after the others as they fill the placeholders.
+func (f *OutputFlags) Apply(c *Config) error {
+	if *f.outfile == "" && *f.dumpData == "" && *f.poster == "" && *f.spectrogram == "" {
+		return errors.New("Must provide a video output destination '-video' (or a data output '-dump-data', '-poster' or '-spectrogram-image')")
+	}
+	for _, out := range []*string{f.outfile, f.dumpData, f.poster, f.spectrogram} {
+		// after the audio and visual flags, which fill the placeholders
+		expanded, err := expandOutput(*out, c)
+		if err != nil {
+			return fmt.Errorf("Bad output path '%s': %s", *out, err)
+		}
+		*out = expanded
+	}
+	if *f.spectrogram != "" && c.Style != styleSpectrogram {
+		return errors.New("Must draw a spectrogram '-style spectrogram' to save it '-spectrogram-image'")
+	}
+	if _, ok := qualityPresets[*f.quality]; !ok {
+		return fmt.Errorf("Unknown quality preset '-quality %s'", *f.quality)
+	}
+	if *f.pixFmt != "" && !outputPixelFormats[*f.pixFmt] {
+		return fmt.Errorf("Unsupported pixel format '-pix-fmt %s'", *f.pixFmt)
+	}
+	for _, out := range []string{*f.outfile, *f.dumpData, *f.poster, *f.spectrogram} {
+		for _, in := range []string{c.AudioFile, c.AudioFile2} {
+			// ffmpeg would happily overwrite the audio with -y
+			if out != "" && out != StdoutFile && in != "" && SamePath(out, in) {
+				return fmt.Errorf("Refusing to overwrite the audio input with the output '%s'", out)
+			}
+		}
+	}
+	if *f.resume && (*f.bitrate != "" || *f.outfile == StdoutFile || c.AudioFile2 != "") {
+		return errors.New("Can't resume a two pass encode, the video on stdout or a crossfade '-resume'")
+	}
+	if *f.transparent {
+		if *f.bitrate != "" {
+			return errors.New("Can't have a target bitrate with a transparent background '-transparent', '-bitrate'")
+		}
+		if ext := strings.ToLower(filepath.Ext(*f.outfile)); *f.format == "" && ext != ".mov" && ext != ".mkv" {
+			return errors.New("A transparent video must be a .mov or .mkv '-transparent'")
+		}
+	}
+	if *f.bitrate != "" && *f.outfile == "" {
+		return errors.New("Must have a video output for a target bitrate '-bitrate'")
+	}
+	if *f.bitrate != "" {
+		quality := false
+		f.fs.Visit(func(fl *flag.Flag) { quality = quality || fl.Name == "quality" })
+		if quality {
+			return errors.New("Can't have a target bitrate and a quality preset '-bitrate', '-quality'")
+		}
+	}
+	for _, kv := range *f.metadata {
+		if !strings.Contains(kv, "=") {
+			return fmt.Errorf("Metadata must be 'key=value' '-metadata %s'", kv)
+		}
+	}
+	if *f.outfile == StdoutFile && *f.format == "" {
+		// there is no extension to go by, and matroska streams fine
+		*f.format = "matroska"
+	}
+	c.VideoFile = *f.outfile
+	c.Format = *f.format
+	c.DataFile = *f.dumpData
+	c.SpectrogramFile = *f.spectrogram
+	c.Poster = *f.poster
+	c.PosterAt = *f.posterAt
+	c.PixelFormat = *f.pixFmt
+	c.CodecProfile = *f.codecProfile
+	// copies, so nothing added to the options changes the presets
+	c.VideoCodecAndOptions = append([]string(nil), qualityPresets[*f.quality]...)
+	if *f.bitrate != "" {
+		c.Bitrate = *f.bitrate
+		c.VideoCodecAndOptions = append([]string(nil), bitrateVideoOptions...)
+	}
+	if *f.transparent {
+		c.Transparent = true
+		c.VideoCodecAndOptions = append([]string(nil), transparentVideoOptions...)
+		if c.PixelFormat == "" {
+			c.PixelFormat = transparentPixelFormat
+		}
+	}
+	if c.PixelFormat == "" {
+		c.PixelFormat = defaultPixelFormats[c.VideoCodecAndOptions[0]]
+	}
+	c.Resume = *f.resume
+	c.SafeAudio = *f.safeAudio
+	c.Quiet = *f.quiet
+	c.Metadata = *f.metadata
+	c.ExtraOutputOptions = strings.Fields(*f.extra)
+	return nil
+}
+
+// parseCurve reads "x:y,x:y,..." points, with x from 0 to 1, sorted by x
+func parseCurve(s string) ([][2]float64, error) {
+	var curve [][2]float64
+	for _, p := range strings.Split(s, ",") {
+		xy := strings.Split(strings.TrimSpace(p), ":")
+		if len(xy) != 2 {
+			return nil, fmt.Errorf("%q is not 'position:value'", p)
+		}
+		x, err := strconv.ParseFloat(xy[0], 64)
+		if err != nil || x < 0 || x > 1 {
+			return nil, fmt.Errorf("position %q must be from 0 to 1", xy[0])
+		}
+		y, err := strconv.ParseFloat(xy[1], 64)
+		if err != nil || y <= 0 {
+			return nil, fmt.Errorf("value %q must be more than 0", xy[1])
+		}
+		curve = append(curve, [2]float64{x, y})
+	}
+	sort.Slice(curve, func(i, j int) bool { return curve[i][X] < curve[j][X] })
+	for k := 1; k < len(curve); k++ {
+		if curve[k][X] == curve[k-1][X] {
+			return nil, fmt.Errorf("position %g is given twice", curve[k][X])
+		}
+	}
+	return curve, nil
+}
+
+// parseList reads "x,y,..." numbers, none for an empty string
+func parseList(s string) ([]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var xs []float64
+	for _, p := range strings.Split(s, ",") {
+		x, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", p)
+		}
+		xs = append(xs, x)
+	}
+	return xs, nil
+}
+
+// SamePath is true if the paths are the same file, or would be once created
+func SamePath(a, b string) bool {
+	if isURL(a) || isURL(b) {
+		return a == b
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA == nil && errB == nil && absA == absB {
+		return true
+	}
+	// they could still be links to the same file
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// parseFrameRate reads a frame rate as a whole number, a fraction
+// "30000/1001" or a decimal. Decimals must be a whole number or an NTSC
+// rate (a whole number * 1000/1001), which are rounded to the fraction.
+func parseFrameRate(s string) (num, den int, err error) {
+	if i := strings.Index(s, "/"); i >= 0 {
+		num, err1 := strconv.Atoi(s[:i])
+		den, err2 := strconv.Atoi(s[i+1:])
+		if err1 != nil || err2 != nil || num <= 0 || den <= 0 {
+			return 0, 0, errors.New("must be a positive fraction like 30000/1001")
+		}
+		return num, den, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return 0, 0, errors.New("must be a positive number")
+	}
+	if f == math.Trunc(f) {
+		return int(f), 1, nil
+	}
+	if n := math.Round(f * 1.001); math.Abs(n*1000/1001-f) < 0.01 {
+		return int(n) * 1000, 1001, nil
+	}
+	return 0, 0, errors.New("must be a whole number or an NTSC rate like 29.97, or give it as a fraction")
+}
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ", ")
+}
+
+func (sl *stringList) Set(v string) error {
+	*sl = append(*sl, v)
+	return nil
+}
@@ -0,0 +1,172 @@
+package vis
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "Rewrite the golden images in testdata from the current drawing")
+
+const (
+	// how far a channel of a pixel can be from the golden image, for
+	// rounding in the rasterizer
+	goldenTolerance = 2
+	// the frames the golden images are drawn after, half a second
+	goldenFrames = 15
+)
+
+// goldenSignal is a fixed signal with something to draw in every style:
+// a steady bass note, and a tone sweeping up through the mids.
+func goldenSignal(sampleRate int, seconds float64) []float64 {
+	samples := make([]float64, int(seconds*float64(sampleRate)))
+	phase := 0.0
+	for i := range samples {
+		t := float64(i) / float64(sampleRate)
+		phase += 2 * math.Pi * (500 + 4000*t) / float64(sampleRate)
+		samples[i] = 0.4*math.Sin(2*math.Pi*110*t) + 0.3*math.Sin(phase)
+	}
+	return samples
+}
+
+// renderGolden draws goldenFrames of the signal in the style, returning
+// the last frame.
+func renderGolden(t *testing.T, style string) *image.RGBA {
+	t.Helper()
+	c := testConfig(t, "-style", style, "-width", "160", "-height", "90")
+	vis, err := NewVisualisation(c)
+	if err != nil {
+		t.Fatalf("NewVisualisation: %v", err)
+	}
+	as := testAudioSource(t, c, goldenSignal(c.SampleRate, 1))
+	af := as.NewFrame()
+	var img *image.RGBA
+	for i := 0; i < goldenFrames; i++ {
+		if err := as.ReadFrame(af); err != nil {
+			t.Fatalf("ReadFrame %d: %v", i, err)
+		}
+		img = vis.CreateFrame(af)
+	}
+	return img
+}
+
+// TestGolden draws the fixed signal in each style and compares it to the
+// PNGs in testdata, so a change to the drawing or the colors shows up.
+// After a change on purpose, 'go test -run TestGolden -update-golden'
+// draws them again, look at them before committing them.
+func TestGolden(t *testing.T) {
+	for _, style := range []string{styleSpectrum, styleWaveform, styleSpectrogram} {
+		t.Run(style, func(t *testing.T) {
+			path := filepath.Join("testdata", "golden-"+style+".png")
+			img := renderGolden(t, style)
+			if *updateGolden {
+				if err := writePNG(path, img); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := readPNG(path)
+			if err != nil {
+				t.Fatalf("%s (run 'go test -run TestGolden -update-golden' to draw it)", err)
+			}
+			if err := compareImages(img, want, goldenTolerance); err != nil {
+				// next to the golden image, to look at them side by side
+				failed := filepath.Join("testdata", "golden-"+style+".failed.png")
+				if err := writePNG(failed, img); err != nil {
+					t.Error(err)
+				}
+				t.Fatalf("%s: %s (drawn as %s)", path, err, failed)
+			}
+		})
+	}
+}
+
+// compareImages is nil if every channel of every pixel is within the
+// tolerance of the golden image's.
+func compareImages(got *image.RGBA, want image.Image, tolerance int) error {
+	if got.Bounds().Size() != want.Bounds().Size() {
+		return fmt.Errorf("the size is %v, want %v", got.Bounds().Size(), want.Bounds().Size())
+	}
+	// the golden image may have been decoded to some other model
+	w := image.NewRGBA(got.Bounds())
+	for y := 0; y < w.Rect.Dy(); y++ {
+		for x := 0; x < w.Rect.Dx(); x++ {
+			w.Set(x, y, want.At(want.Bounds().Min.X+x, want.Bounds().Min.Y+y))
+		}
+	}
+	bad, worst := 0, 0
+	for i := range got.Pix {
+		d := int(got.Pix[i]) - int(w.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		if d > tolerance {
+			bad++
+		}
+		if d > worst {
+			worst = d
+		}
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d channels are more than %d off, up to %d", bad, tolerance, worst)
+	}
+	return nil
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(path string, img image.Image) error {
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
+
+func TestCompareImages(t *testing.T) {
+	a := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	b := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	if err := compareImages(a, b, 0); err != nil {
+		t.Errorf("the same images: %v", err)
+	}
+	b.Pix[5] = 2
+	if err := compareImages(a, b, 2); err != nil {
+		t.Errorf("within the tolerance: %v", err)
+	}
+	b.Pix[6] = 3
+	if err := compareImages(a, b, 2); err == nil {
+		t.Error("a channel past the tolerance wasn't different")
+	}
+	if err := compareImages(a, image.NewRGBA(image.Rect(0, 0, 4, 2)), 2); err == nil {
+		t.Error("a different size wasn't different")
+	}
+	// through a PNG and back is the same
+	path := filepath.Join(t.TempDir(), "a.png")
+	a.Pix[0], a.Pix[3] = 200, 255
+	if err := writePNG(path, a); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readPNG(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compareImages(a, read, 0); err != nil {
+		t.Errorf("read back: %v", err)
+	}
+}
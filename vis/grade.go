@@ -0,0 +1,69 @@
+package vis
+
+import (
+	"image"
+	"math"
+)
+
+// Grade adjusts the colors of the finished frame to match a look.
+// Brightness, contrast and gamma only depend on each 8bit value, so they
+// are worked out once into a lookup table. Saturation mixes each color
+// with the pixel's gray.
+type Grade struct {
+	lut        [256]uint8
+	saturation int // 256 is 1, unchanged
+}
+
+// NewGrade creates the grade from the config, nil if it changes nothing
+func NewGrade(c *Config) *Grade {
+	if c.Gamma == 1 && c.Brightness == 0 && c.Contrast == 1 && c.Saturation == 1 {
+		return nil
+	}
+	g := &Grade{saturation: int(math.Round(c.Saturation * 256))}
+	for i := range g.lut {
+		x := float64(i) / 255
+		// contrast about the middle, then the brightness on top
+		x = (x-0.5)*c.Contrast + 0.5 + c.Brightness
+		x = math.Max(0, math.Min(1, x))
+		x = math.Pow(x, 1/c.Gamma)
+		g.lut[i] = uint8(math.Round(x * 255))
+	}
+	return g
+}
+
+// Apply the grade to the image, in place
+func (g *Grade) Apply(img *image.RGBA) {
+	pix := img.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		a := int(pix[i+3])
+		if a == 0 {
+			// nothing to see (and it must stay black to be premultiplied)
+			continue
+		}
+		var rgb [3]int
+		for k := 0; k < 3; k++ {
+			c := int(pix[i+k])
+			if a == 255 {
+				rgb[k] = int(g.lut[c])
+				continue
+			}
+			// the table is for the color, not the premultiplied value
+			rgb[k] = int(g.lut[c*255/a]) * a / 255
+		}
+		if g.saturation != 256 {
+			// mixing is linear, so it works on premultiplied colors too
+			gray := (rgb[0]*299 + rgb[1]*587 + rgb[2]*114) / 1000
+			for k := range rgb {
+				rgb[k] = gray + (rgb[k]-gray)*g.saturation/256
+			}
+		}
+		for k, c := range rgb {
+			if c < 0 {
+				c = 0
+			} else if c > a {
+				c = a
+			}
+			pix[i+k] = uint8(c)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package vis
+
+import (
+	"image"
+	"testing"
+)
+
+// gradePixel applies the grade to one pixel
+func gradePixel(g *Grade, px [4]uint8) [4]uint8 {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	copy(img.Pix, px[:])
+	g.Apply(img)
+	return [4]uint8(img.Pix)
+}
+
+func TestNewGradeNothing(t *testing.T) {
+	if g := NewGrade(&Config{Gamma: 1, Contrast: 1, Saturation: 1}); g != nil {
+		t.Fatal("a grade that changes nothing isn't nil")
+	}
+}
+
+func TestGrade(t *testing.T) {
+	for _, tc := range []struct {
+		name                                    string
+		gamma, brightness, contrast, saturation float64
+		in, want                                [4]uint8
+	}{
+		{"gamma", 2, 0, 1, 1, [4]uint8{64, 0, 255, 255}, [4]uint8{128, 0, 255, 255}},
+		{"brightness", 1, 0.2, 1, 1, [4]uint8{0, 128, 255, 255}, [4]uint8{51, 179, 255, 255}},
+		{"contrast", 1, 0, 0.5, 1, [4]uint8{0, 128, 255, 255}, [4]uint8{64, 128, 191, 255}},
+		{"gray", 1, 0, 1, 0, [4]uint8{255, 0, 0, 255}, [4]uint8{76, 76, 76, 255}},
+		{"saturated", 1, 0, 1, 2, [4]uint8{200, 100, 50, 255}, [4]uint8{255, 76, 0, 255}},
+		// transparent stays black, or it isn't premultiplied any more
+		{"transparent", 1, 0.5, 1, 1, [4]uint8{0, 0, 0, 0}, [4]uint8{0, 0, 0, 0}},
+	} {
+		g := NewGrade(&Config{Gamma: tc.gamma, Brightness: tc.brightness, Contrast: tc.contrast, Saturation: tc.saturation})
+		if got := gradePixel(g, tc.in); got != tc.want {
+			t.Errorf("%s: %v graded to %v, want %v", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+// TestGradePremultiplied grades a half transparent pixel as its color,
+// and keeps it premultiplied.
+func TestGradePremultiplied(t *testing.T) {
+	g := NewGrade(&Config{Gamma: 2, Brightness: 0.1, Contrast: 1.5, Saturation: 1.5})
+	const a = 128
+	straight := [4]uint8{200, 100, 10, 255}
+	graded := gradePixel(g, straight)
+	got := gradePixel(g, [4]uint8{200 * a / 255, 100 * a / 255, 10 * a / 255, a})
+	for k := 0; k < 3; k++ {
+		if got[k] > a {
+			t.Errorf("channel %d is %d, more than the alpha %d", k, got[k], a)
+		}
+		// the premultiplied values lose a bit to rounding
+		if want := int(graded[k]) * a / 255; int(got[k]) < want-2 || int(got[k]) > want+2 {
+			t.Errorf("channel %d is %d, want %d (%d premultiplied)", k, got[k], want, graded[k])
+		}
+	}
+	if got[3] != a {
+		t.Errorf("the alpha is %d, want %d", got[3], a)
+	}
+}
+
+// benchFrame is a w x h frame of every brightness, for the benchmarks
+func benchFrame(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		x := uint8(i / 4 % w * 256 / w)
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = x, 255-x, x/2, 255
+	}
+	return img
+}
+
+func BenchmarkGrade(b *testing.B) {
+	c, err := NewConfig("-width", "1280", "-height", "720", "-gamma", "1.2", "-brightness", "0.05", "-contrast", "1.1", "-saturation", "1.3")
+	if err != nil {
+		b.Fatalf("NewConfig: %v", err)
+	}
+	g := NewGrade(c)
+	img := benchFrame(c.Width, c.Height)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g.Apply(img)
+	}
+}
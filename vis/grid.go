@@ -0,0 +1,112 @@
+package vis
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/tdewolff/canvas"
+)
+
+const (
+	gridWidth   = 1   // pixels
+	gridOpacity = 0.3 // faint, it's only for reference
+	gridSteps   = 64  // points along each ring, enough to look round
+)
+
+// drawGrid draws the reference lines behind the spectrums, to read them
+// by: rings at the grid levels (fractions of the headroom, so 1 is as far
+// as a spectrum can go) and lines across at the grid frequencies.
+func (v *Visualisation) drawGrid(ctx *canvas.Context, radius, headroom float64, pos func(f, r float64) [2]float64, fill func(p *canvas.Path)) {
+	if len(v.gridLevels) == 0 && len(v.gridHz) == 0 {
+		return
+	}
+	// the rings are where a spectrum of that height would reach
+	var rings []float64
+	for _, level := range v.gridLevels {
+		a := level * headroom
+		if v.direction != directionInward {
+			rings = append(rings, radius+a)
+		}
+		if v.direction != directionOutward {
+			rings = append(rings, math.Max(0, radius-a))
+		}
+	}
+	// and the lines go as far as the spectrums can
+	inner, outer := radius, radius+headroom
+	switch v.direction {
+	case directionInward:
+		inner, outer = math.Max(0, radius-headroom), radius
+	case directionBoth:
+		inner = math.Max(0, radius-headroom)
+	}
+
+	p := &canvas.Path{}
+	for _, sx := range v.sides {
+		for _, r := range rings {
+			for i := 0; i <= gridSteps; i++ {
+				pt := pos(float64(i)/gridSteps, r)
+				if i == 0 {
+					p.MoveTo(sx*pt[X], pt[Y])
+				} else {
+					p.LineTo(sx*pt[X], pt[Y])
+				}
+			}
+		}
+		for _, hz := range v.gridHz {
+			for _, f := range v.gridPositions(hz) {
+				a, b := pos(f, inner), pos(f, outer)
+				p.MoveTo(sx*a[X], a[Y])
+				p.LineTo(sx*b[X], b[Y])
+			}
+		}
+	}
+	ctx.SetFillColor(color.Transparent)
+	ctx.SetStrokeColor(withOpacity(v.gridColor, gridOpacity))
+	ctx.SetStrokeWidth(gridWidth)
+	fill(p)
+	ctx.SetStrokeColor(color.Transparent)
+	ctx.SetStrokeWidth(0)
+}
+
+// gridPositions are where (0 to 1 along the spectrum) a frequency is
+// drawn, to within a bin. The whole FFT (without '-min-hz', '-max-hz' or
+// '-scale') has its mirror image too, so the frequency is there twice.
+// The waveform has no frequencies, so nowhere.
+func (v *Visualisation) gridPositions(hz float64) []float64 {
+	if v.style == styleWaveform {
+		return nil
+	}
+	lo, hi := v.minHz, v.maxHz
+	if hi == 0 {
+		hi = float64(v.sampleRate) / 2
+	}
+	if hz < lo || hz > hi {
+		return nil
+	}
+	var fs []float64
+	switch {
+	case v.scaled != nil:
+		// the scales only go up, so home in on it
+		scale := frequencyScales[v.scale]
+		a, b := 0.0, 1.0
+		for i := 0; i < 32; i++ {
+			if t := (a + b) / 2; scale(lo, hi, t) < hz {
+				a = t
+			} else {
+				b = t
+			}
+		}
+		fs = []float64{(a + b) / 2}
+	case v.minHz > 0 || v.maxHz > 0:
+		fs = []float64{(hz - lo) / (hi - lo)}
+	default:
+		f := hz / float64(v.sampleRate)
+		fs = []float64{f, 1 - f}
+	}
+	if v.reverseFreq {
+		for i := range fs {
+			fs[i] = 1 - fs[i]
+		}
+	}
+	return fs
+}
@@ -0,0 +1,93 @@
+package vis
+
+// FrameInterpolator lets us render video at a multiple of the analysis
+// frame rate. Each analysed AudioFrame becomes `steps` frames, linearly
+// interpolated from the previous analysed frame up to this one.
+// This puts the video a fraction of a frame behind the audio, but
+// at 30fps analysis that is not noticeable.
+type FrameInterpolator struct {
+	steps int
+	prev  *AudioFrame // a copy of the last analysed frame
+	out   *AudioFrame // the frame we fill and hand out
+}
+
+// NewFrameInterpolator creates an interpolator producing `steps` frames
+// per analysed frame. 1 step just passes the frames through.
+func NewFrameInterpolator(steps int) *FrameInterpolator {
+	return &FrameInterpolator{steps: steps}
+}
+
+// Interpolate calls onFrame for each of the frames between the previous
+// analysed frame and this one. The last call is always the frame itself.
+// As with AudioSource.StartProcessing the frame passed to onFrame is reused.
+func (fi *FrameInterpolator) Interpolate(af *AudioFrame, onFrame func(f *AudioFrame) error) error {
+	if fi.steps <= 1 {
+		return onFrame(af)
+	}
+	if fi.prev == nil {
+		// first frame, nothing to interpolate from, so start from silence.
+		fi.prev = silentFrame(af)
+		fi.out = silentFrame(af)
+	}
+	for k := 1; k <= fi.steps; k++ {
+		lerpFrame(fi.out, fi.prev, af, float64(k)/float64(fi.steps))
+		if err := onFrame(fi.out); err != nil {
+			return err
+		}
+	}
+	// keep this one for next time
+	copyFrame(fi.prev, af)
+	return nil
+}
+
+// silentFrame is a frame of silence the same size as af (with the
+// same channels).
+func silentFrame(af *AudioFrame) *AudioFrame {
+	f := &AudioFrame{
+		data:       make([]float64, len(af.data)),
+		freq:       make([]float64, len(af.freq)),
+		sampleRate: af.sampleRate,
+	}
+	for _, ch := range af.stereo {
+		f.stereo = append(f.stereo, silentFrame(ch))
+	}
+	for _, stem := range af.stems {
+		f.stems = append(f.stems, silentFrame(stem))
+	}
+	return f
+}
+
+// lerpFrame fills out with the frame t of the way from a to b
+func lerpFrame(out, a, b *AudioFrame, t float64) {
+	lerp(out.data, a.data, b.data, t)
+	lerp(out.freq, a.freq, b.freq, t)
+	out.centroid = a.centroid + (b.centroid-a.centroid)*t
+	out.rms = a.rms + (b.rms-a.rms)*t
+	for i := range out.stereo {
+		lerpFrame(out.stereo[i], a.stereo[i], b.stereo[i], t)
+	}
+	for i := range out.stems {
+		lerpFrame(out.stems[i], a.stems[i], b.stems[i], t)
+	}
+}
+
+// copyFrame copies the analysis of src into dst
+func copyFrame(dst, src *AudioFrame) {
+	copy(dst.data, src.data)
+	copy(dst.freq, src.freq)
+	dst.centroid = src.centroid
+	dst.rms = src.rms
+	for i := range dst.stereo {
+		copyFrame(dst.stereo[i], src.stereo[i])
+	}
+	for i := range dst.stems {
+		copyFrame(dst.stems[i], src.stems[i])
+	}
+}
+
+// lerp fills dst with the values t of the way from a to b
+func lerp(dst, a, b []float64, t float64) {
+	for i := range dst {
+		dst[i] = a[i] + (b[i]-a[i])*t
+	}
+}
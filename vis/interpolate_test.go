@@ -0,0 +1,83 @@
+package vis
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// interpolated collects the freq of each frame the interpolator hands out
+func interpolated(t *testing.T, fi *FrameInterpolator, af *AudioFrame) [][]float64 {
+	t.Helper()
+	var got [][]float64
+	err := fi.Interpolate(af, func(f *AudioFrame) error {
+		got = append(got, append([]float64(nil), f.freq...))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Interpolate: %v", err)
+	}
+	return got
+}
+
+func TestFrameInterpolator(t *testing.T) {
+	fi := NewFrameInterpolator(3)
+	// the first frame comes up from silence
+	got := interpolated(t, fi, &AudioFrame{data: make([]float64, 2), freq: []float64{3, 6}, rms: 0.3})
+	if want := [][]float64{{1, 2}, {2, 4}, {3, 6}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the first frame is %v, want %v", got, want)
+	}
+	// the next from the one before, ending on it exactly
+	got = interpolated(t, fi, &AudioFrame{data: make([]float64, 2), freq: []float64{6, 0}, rms: 0.6})
+	if want := [][]float64{{4, 4}, {5, 2}, {6, 0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the second frame is %v, want %v", got, want)
+	}
+	if fi.out.rms != 0.6 {
+		t.Errorf("the rms is %g, want 0.6", fi.out.rms)
+	}
+}
+
+func TestFrameInterpolatorChannels(t *testing.T) {
+	fi := NewFrameInterpolator(2)
+	channel := func(x float64) *AudioFrame {
+		return &AudioFrame{data: make([]float64, 1), freq: []float64{x}}
+	}
+	af := channel(2)
+	af.stereo = []*AudioFrame{channel(4), channel(8)}
+	af.stems = []*AudioFrame{channel(16)}
+	var got [][]float64
+	fi.Interpolate(af, func(f *AudioFrame) error {
+		got = append(got, []float64{f.freq[0], f.Channel(0).freq[0], f.Channel(1).freq[0], f.Stem(0).freq[0]})
+		return nil
+	})
+	if want := [][]float64{{1, 2, 4, 8}, {2, 4, 8, 16}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the frames (mix, left, right, stem) are %v, want %v", got, want)
+	}
+}
+
+func TestFrameInterpolatorPassesThrough(t *testing.T) {
+	af := &AudioFrame{freq: []float64{1}}
+	calls := 0
+	NewFrameInterpolator(1).Interpolate(af, func(f *AudioFrame) error {
+		if f != af {
+			t.Error("1 step didn't pass the frame itself through")
+		}
+		calls++
+		return nil
+	})
+	if calls != 1 {
+		t.Errorf("1 step made %d frames", calls)
+	}
+}
+
+func TestFrameInterpolatorStopsOnError(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := NewFrameInterpolator(4).Interpolate(&AudioFrame{freq: []float64{1}}, func(*AudioFrame) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Interpolate = %v after %d frames, want %v after 1", err, calls, stop)
+	}
+}
@@ -0,0 +1,172 @@
+// Package vis decodes audio with ffmpeg, analyses it and draws a circular
+// spectrum (or waveform, or spectrogram) for each frame. The command in the
+// repository's root is a thin wrapper round it: its flags come from here
+// too, so NewConfig takes the same ones.
+package vis
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"image"
+	"io"
+	"math"
+)
+
+// NewConfig is a config for Render (or Frames) from the same flags as the
+// preview command, the audio and the visual ones, so a program drawing the
+// frames itself gets the exact look of the command line, e.g.
+//
+//	NewConfig("-audio", "song.mp3", "-style", "waveform", "-resolution", "square")
+//
+// The '-audio' can be left out for a Renderer, which doesn't read any.
+// A bad value is an error, with the same message as the commands'. Reading
+// the audio (Render and Frames, which estimate a '-bpm auto' first) needs
+// ffmpeg in the path, a Renderer doesn't.
+func NewConfig(args ...string) (*Config, error) {
+	fs := flag.NewFlagSet("visualisation", flag.ContinueOnError)
+	// the error says what was wrong, without the usage
+	fs.SetOutput(io.Discard)
+	af, vf := AddAudioFlags(fs), AddVisualFlags(fs)
+	af.optional = true
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c := DefaultConfig()
+	if err := af.Apply(c); err != nil {
+		return nil, err
+	}
+	if err := vf.Apply(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Render decodes and analyses the audio in the config and draws every
+// frame, handing each to onFrame instead of encoding a video. The frame
+// number counts from 0. The image is reused for the next frame, so copy it
+// to keep it. An error from onFrame stops the rendering and is returned,
+// cancelling the context stops it without one. Renders share nothing, so
+// any number can run at once.
+func Render(ctx context.Context, c *Config, onFrame func(frame int, img *image.RGBA) error) error {
+	// the tempo goes in a copy, the config can be shared by other renders
+	tc := *c
+	tc.Layers = append([]Config(nil), c.Layers...)
+	if err := FindTempo(ctx, &tc); err != nil {
+		return err
+	}
+	c = &tc
+	vis, err := NewVisualisation(c)
+	if err != nil {
+		return err
+	}
+	process, err := openAudio(ctx, c, nil)
+	if err != nil {
+		return err
+	}
+	frame := 0
+	_, err = renderFrames(ctx, c, vis, process, func(img *image.RGBA) error {
+		err := onFrame(frame, img)
+		frame++
+		return err
+	}, nil, nil, nil, nil)
+	return err
+}
+
+// Frames is Render as a channel, which is closed after the last frame.
+// Each image is a copy, so it can be kept. Wait returns Render's error
+// once the channel is closed. To stop early cancel the context, the
+// rendering is stuck until the next frame is taken otherwise.
+func Frames(ctx context.Context, c *Config) (frames <-chan *image.RGBA, wait func() error) {
+	ch := make(chan *image.RGBA)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		errc <- Render(ctx, c, func(frame int, img *image.RGBA) error {
+			cp := image.NewRGBA(img.Rect)
+			copy(cp.Pix, img.Pix)
+			select {
+			case ch <- cp:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return ch, func() error {
+		return <-errc
+	}
+}
+
+// errTooFewMagnitudes is a RenderFrame without enough to draw
+var errTooFewMagnitudes = errors.New("need at least 2 magnitudes to draw a frame")
+
+// Renderer draws frames from spectrums the caller already has (from their
+// own FFT, say), with the same easing, history and colors as the command
+// but without ffmpeg or the analysis. A Renderer draws one frame at a time,
+// but separate ones are independent and can draw at once.
+type Renderer struct {
+	vis   *Visualisation
+	frame *AudioFrame
+}
+
+// NewRenderer creates a renderer with the look in the config. Of the audio
+// settings only the frame rate, SampleRate and MagnitudeGain matter, and
+// it doesn't need ffmpeg. Without the audio there's no tempo to estimate,
+// so AutoBPM is an error.
+func NewRenderer(c *Config) (*Renderer, error) {
+	if len(autoTempo(c)) > 0 {
+		return nil, errors.New("Can't estimate the tempo without the audio, give it instead '-bpm'")
+	}
+	vis, err := NewVisualisation(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Renderer{
+		vis:   vis,
+		frame: &AudioFrame{sampleRate: c.SampleRate, gain: c.MagnitudeGain},
+	}, nil
+}
+
+// RenderFrame draws the next frame from the magnitudes, evenly spaced from
+// 0Hz up to half the SampleRate like the first half of an FFT, scaled like
+// the analysis' (see Config.MagnitudeGain: a full scale sine is about half
+// the gain). For the waveform style they are the samples, -1 to 1, instead.
+// The image is reused for the next frame, so copy it to keep it. There
+// need to be at least 2 magnitudes to draw a line between, fewer is an
+// error (and no frame).
+func (r *Renderer) RenderFrame(magnitudes []float64) (*image.RGBA, error) {
+	af := r.frame
+	n := len(magnitudes)
+	if n < 2 {
+		return nil, errTooFewMagnitudes
+	}
+	if r.vis.style == styleWaveform {
+		af.data = append(af.data[:0], magnitudes...)
+		af.rms = rms(af.data)
+		af.runTimeDomainAnalysis()
+		return r.vis.CreateFrame(af), nil
+	}
+	// the visualisation wants the whole FFT, which mirrors the first half
+	s := 2 * n
+	if cap(af.freq) < s {
+		af.freq = make([]float64, s)
+	}
+	af.freq = af.freq[:s]
+	var sum, weighted, power float64
+	for i, m := range magnitudes {
+		af.freq[i] = m
+		af.freq[s-1-i] = m
+		sum += m
+		weighted += m * float64(i)
+		power += (m / af.gain) * (m / af.gain)
+	}
+	af.centroid = 0
+	if sum > 0 {
+		af.centroid = weighted / sum * float64(af.sampleRate) / float64(s)
+	}
+	// Parseval: the power of the samples is in the spectrum, both halves
+	// of it (near enough, without the window)
+	af.rms = math.Sqrt(2 * power)
+	return r.vis.CreateFrame(af), nil
+}
@@ -0,0 +1,173 @@
+package vis
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewConfig(t *testing.T) {
+	c, err := NewConfig("-style", "waveform", "-resolution", "square")
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	if c.Style != styleWaveform || c.Width != c.Height {
+		t.Fatalf("NewConfig = style %q, %dx%d, want a square waveform", c.Style, c.Width, c.Height)
+	}
+}
+
+func TestNewConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-style", "bars"}, "'-style bars'"},
+		{[]string{"-audio", "testdata/missing.mp3"}, "Can't read the audio input"},
+		{[]string{"-gain", "0"}, "'-gain'"},
+		{[]string{"-layer", "-style spectrogram"}, "only be the bottom layer"},
+		{[]string{"-layer", "-width 100"}, "only change how it is drawn"},
+		{[]string{"-no-such-flag"}, "no-such-flag"},
+	} {
+		// getting here at all means it didn't exit
+		_, err := NewConfig(tc.args...)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("NewConfig(%q) = %v, want an error with %q", tc.args, err, tc.want)
+		}
+	}
+}
+
+func TestRenderWithoutFFMpeg(t *testing.T) {
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	c.FFMpegPath = ""
+	err = Render(context.Background(), c, func(int, *image.RGBA) error { return nil })
+	if err != ErrNoFFMpeg {
+		t.Fatalf("Render = %v, want %v", err, ErrNoFFMpeg)
+	}
+}
+
+func TestPreviewWithoutFFPlay(t *testing.T) {
+	c := testConfig(t)
+	c.FFPlayPath = ""
+	if err := Preview(context.Background(), c, nil); err != ErrNoFFPlay {
+		t.Fatalf("Preview = %v, want %v", err, ErrNoFFPlay)
+	}
+}
+
+func TestAutoBPM(t *testing.T) {
+	// the flags don't read the audio, so it's estimated later
+	c, err := NewConfig("-bpm", "auto", "-beat-pulse", "0.1")
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	if !c.AutoBPM || c.BPM != 0 {
+		t.Fatalf("NewConfig = AutoBPM %v, BPM %g, want it left to estimate", c.AutoBPM, c.BPM)
+	}
+	if _, err := NewRenderer(c); err == nil {
+		t.Error("NewRenderer can't estimate the tempo, but it had no error")
+	}
+	c.FFMpegPath = ""
+	err = Render(context.Background(), c, func(int, *image.RGBA) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "'-bpm auto'") {
+		t.Errorf("Render = %v, want the tempo estimate to fail", err)
+	}
+	if !c.AutoBPM {
+		t.Error("Render changed the config it was given")
+	}
+	if _, err := NewConfig("-bpm", "auto"); err == nil {
+		t.Error("nothing moves with the beat, but NewConfig had no error")
+	}
+}
+
+func TestRenderFrame(t *testing.T) {
+	for _, style := range []string{styleSpectrum, styleWaveform, styleSpectrogram} {
+		c, err := NewConfig("-style", style, "-width", "64", "-height", "64")
+		if err != nil {
+			t.Fatalf("NewConfig: %v", err)
+		}
+		// a Renderer never needs ffmpeg
+		c.FFMpegPath = ""
+		r, err := NewRenderer(c)
+		if err != nil {
+			t.Fatalf("NewRenderer(%s): %v", style, err)
+		}
+		for _, magnitudes := range [][]float64{nil, {}, {1}} {
+			if img, err := r.RenderFrame(magnitudes); err != errTooFewMagnitudes || img != nil {
+				t.Errorf("RenderFrame(%v) for %s = %v, %v, want nil, %v", magnitudes, style, img, err, errTooFewMagnitudes)
+			}
+		}
+		for _, n := range []int{2, 3, 512} {
+			magnitudes := make([]float64, n)
+			for i := range magnitudes {
+				magnitudes[i] = 0.5
+			}
+			img, err := r.RenderFrame(magnitudes)
+			if err != nil {
+				t.Fatalf("RenderFrame(%d) for %s: %v", n, style, err)
+			}
+			if w, h := img.Rect.Dx(), img.Rect.Dy(); w != 64 || h != 64 {
+				t.Errorf("RenderFrame(%d) for %s is %dx%d, want 64x64", n, style, w, h)
+			}
+		}
+	}
+}
+
+// renderSweep draws frames of a peak moving up the spectrum, returning a
+// copy of the last
+func renderSweep(t *testing.T, c *Config, frames int) []byte {
+	r, err := NewRenderer(c)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	magnitudes := make([]float64, 256)
+	var img *image.RGBA
+	for f := 0; f < frames; f++ {
+		for i := range magnitudes {
+			magnitudes[i] = 0
+		}
+		magnitudes[(f*7)%len(magnitudes)] = 20
+		if img, err = r.RenderFrame(magnitudes); err != nil {
+			t.Error(err)
+			return nil
+		}
+	}
+	return append([]byte(nil), img.Pix...)
+}
+
+func TestRenderersConcurrently(t *testing.T) {
+	// run with -race: renderers share no state, so drawing at once
+	// gives the same frames as drawing one after the other
+	styles := []string{styleSpectrum, styleWaveform, styleSpectrogram}
+	configs := make([]*Config, len(styles))
+	want := make([][]byte, len(styles))
+	for i, style := range styles {
+		c, err := NewConfig("-style", style, "-width", "64", "-height", "64", "-history", "4")
+		if err != nil {
+			t.Fatalf("NewConfig: %v", err)
+		}
+		configs[i] = c
+		want[i] = renderSweep(t, c, 30)
+	}
+	const each = 4
+	got := make([][]byte, len(styles)*each)
+	var wg sync.WaitGroup
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = renderSweep(t, configs[i%len(configs)], 30)
+		}(i)
+	}
+	wg.Wait()
+	for i := range got {
+		if !bytes.Equal(got[i], want[i%len(want)]) {
+			t.Errorf("renderer %d (%s) drew a different frame at the same time as others", i, styles[i%len(styles)])
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package vis
+
+import (
+	"image/color"
+	"math/rand"
+)
+
+const (
+	paletteDefault = "default" // the hand picked spectrumStyles colors
+	paletteRandom  = "random"  // generated from the seed
+)
+
+// randomPalette makes n colors for the spectrums from the seed: evenly
+// spaced hues across a random slice of the color wheel, getting lighter
+// towards the end, which is always white like the default palette.
+func randomPalette(n int, seed int64) []color.Color {
+	r := rand.New(rand.NewSource(seed))
+	start := r.Float64() * 360
+	span := 90 + r.Float64()*180 // wide enough to tell them apart
+	if r.Intn(2) == 0 {
+		// run round the wheel the other way
+		span = -span
+	}
+	saturation := 0.7 + r.Float64()*0.3
+
+	colors := make([]color.Color, n)
+	for i := 0; i < n-1; i++ {
+		t := float64(i) / float64(n-1)
+		colors[i] = hsv(start+span*t, saturation*(1-t/2), 0.6+t*0.4)
+	}
+	colors[n-1] = color.White
+	return colors
+}
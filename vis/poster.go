@@ -0,0 +1,70 @@
+package vis
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"math"
+	"os"
+)
+
+// Poster keeps a single frame of the video to save as a PNG, for the
+// video platforms that want a thumbnail. Either the frame at a given
+// time, or the loudest one.
+type Poster struct {
+	path    string
+	at      int     // the frame to keep, -1 for the loudest
+	loudest float64 // the rms of the frame we have, when looking for the loudest
+	img     *image.RGBA
+}
+
+// NewPoster creates the poster for the config. The time is rounded to
+// the nearest frame.
+func NewPoster(c *Config) *Poster {
+	at := -1
+	if c.PosterAt >= 0 {
+		at = int(math.Round(c.PosterAt * OutputFrameRate(c)))
+	}
+	return &Poster{path: c.Poster, at: at, loudest: -1}
+}
+
+// Wants is true if the frame should be kept, so it needs drawing.
+// It is safe to call on a nil Poster (which wants nothing).
+func (p *Poster) Wants(frame int, af *AudioFrame) bool {
+	if p == nil {
+		return false
+	}
+	if p.at >= 0 {
+		return frame == p.at
+	}
+	if l := af.RMS(); l > p.loudest {
+		p.loudest = l
+		return true
+	}
+	return false
+}
+
+// Keep copies the frame, as the Visualisation draws over the same image
+// every frame.
+func (p *Poster) Keep(img *image.RGBA) {
+	if p.img == nil {
+		p.img = image.NewRGBA(img.Bounds())
+	}
+	copy(p.img.Pix, img.Pix)
+}
+
+// Save writes the kept frame to the file
+func (p *Poster) Save() error {
+	if p.img == nil {
+		return errors.New("no poster frame, is '-poster-at' after the end of the audio?")
+	}
+	f, err := os.Create(p.path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, p.img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,152 @@
+package vis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+)
+
+// AudioInfo is what ffprobe tells us about an audio file
+type AudioInfo struct {
+	Duration   float64           // seconds, 0 if unknown (e.g. a live stream)
+	SampleRate int               // of the first audio stream
+	Channels   int               // of the first audio stream
+	Codec      string            // of the first audio stream, e.g. "mp3"
+	Metadata   map[string]string // the file's tags (title, artist, ...)
+}
+
+// ffprobeOutput is the part of `ffprobe -print_format json` we read.
+// ffprobe gives the numbers with a decimal point as strings.
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		CodecName  string `json:"codec_name"`
+		SampleRate string `json:"sample_rate"`
+		Channels   int    `json:"channels"`
+		Duration   string `json:"duration"`
+		Tags       struct {
+			Duration string `json:"DURATION"` // matroska has it here instead, as hh:mm:ss.nnn
+		} `json:"tags"`
+	} `json:"streams"`
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+// probeAudio asks ffprobe about the file and its first audio stream,
+// with the input options (see Config.InputOptions) before it.
+// Without ffprobe (an empty path) it returns a nil info and no error,
+// so the caller can carry on with the defaults.
+func probeAudio(ffprobe string, options []string, file string) (*AudioInfo, error) {
+	if ffprobe == "" {
+		return nil, nil
+	}
+	args := []string{
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+	}
+	args = append(args, options...)
+	out, err := exec.Command(ffprobe, append(args, file)...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseAudioInfo(out)
+}
+
+// parseAudioInfo reads ffprobe's JSON for probeAudio
+func parseAudioInfo(out []byte) (*AudioInfo, error) {
+	var probed ffprobeOutput
+	if err := json.Unmarshal(out, &probed); err != nil {
+		return nil, err
+	}
+	info := &AudioInfo{Metadata: probed.Format.Tags}
+	found := false
+	for _, s := range probed.Streams {
+		if s.CodecType != "audio" {
+			continue
+		}
+		info.Codec = s.CodecName
+		info.Channels = s.Channels
+		info.SampleRate, _ = strconv.Atoi(s.SampleRate)
+		// the stream's is more accurate, but not every container has one
+		info.Duration, _ = strconv.ParseFloat(s.Duration, 64)
+		found = true
+		break
+	}
+	if !found {
+		return nil, errors.New("no audio stream found")
+	}
+	if info.Duration == 0 {
+		info.Duration, _ = strconv.ParseFloat(probed.Format.Duration, 64)
+	}
+	return info, nil
+}
+
+// StreamDurations asks ffprobe how long the first video and audio
+// streams of an output are in seconds, 0 if it doesn't say.
+func StreamDurations(ffprobe, file string) (video, audio float64, err error) {
+	out, err := exec.Command(ffprobe,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_streams",
+		file,
+	).Output()
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseDurations(out)
+}
+
+// parseDurations reads ffprobe's JSON for StreamDurations
+func parseDurations(out []byte) (video, audio float64, err error) {
+	var probed ffprobeOutput
+	if err := json.Unmarshal(out, &probed); err != nil {
+		return 0, 0, err
+	}
+	for i := len(probed.Streams) - 1; i >= 0; i-- {
+		// backwards, so the first of each type wins
+		s := probed.Streams[i]
+		d, err := strconv.ParseFloat(s.Duration, 64)
+		if err != nil {
+			d = parseClock(s.Tags.Duration)
+		}
+		switch s.CodecType {
+		case "video":
+			video = d
+		case "audio":
+			audio = d
+		}
+	}
+	return video, audio, nil
+}
+
+// parseClock reads hh:mm:ss.nnn into seconds, 0 if it can't
+func parseClock(s string) float64 {
+	var h, m int
+	var sec float64
+	if _, err := fmt.Sscanf(s, "%d:%d:%f", &h, &m, &sec); err != nil {
+		return 0
+	}
+	return float64(h*3600+m*60) + sec
+}
+
+// nativeSampleRate is the rate to analyse the audio at: its own, so
+// ffmpeg doesn't have to resample it. The frames don't have to be a whole
+// number of samples, see AudioSource.samplesAt. Without ffprobe it is
+// samplingRate.
+func nativeSampleRate(c *Config) int {
+	rate := samplingRate
+	info, err := probeAudio(c.FFProbePath, c.InputOptions, c.AudioFile)
+	if err != nil {
+		log.Printf("Couldn't probe the audio sample rate, assuming %d: %s", samplingRate, err)
+	} else if info != nil && info.SampleRate > 0 {
+		rate = info.SampleRate
+	}
+	return rate
+}
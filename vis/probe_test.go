@@ -0,0 +1,176 @@
+package vis
+
+import (
+	"context"
+	"flag"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseAudioInfo(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		json string
+		want *AudioInfo
+	}{
+		{"mp3", `{
+			"streams": [{"codec_type": "audio", "codec_name": "mp3", "sample_rate": "44100", "channels": 2, "duration": "12.500000"}],
+			"format": {"duration": "12.512000", "tags": {"title": "Song", "artist": "Band"}}
+		}`, &AudioInfo{Duration: 12.5, SampleRate: 44100, Channels: 2, Codec: "mp3", Metadata: map[string]string{"title": "Song", "artist": "Band"}}},
+		// cover art first, and no stream duration as in matroska
+		{"cover art", `{
+			"streams": [
+				{"codec_type": "video", "codec_name": "mjpeg", "duration": "1.0"},
+				{"codec_type": "audio", "codec_name": "opus", "sample_rate": "48000", "channels": 1},
+				{"codec_type": "audio", "codec_name": "aac", "sample_rate": "22050", "channels": 6}
+			],
+			"format": {"duration": "3.25"}
+		}`, &AudioInfo{Duration: 3.25, SampleRate: 48000, Channels: 1, Codec: "opus"}},
+		// a live stream has no duration at all
+		{"stream", `{
+			"streams": [{"codec_type": "audio", "codec_name": "aac", "sample_rate": "44100", "channels": 2}],
+			"format": {}
+		}`, &AudioInfo{SampleRate: 44100, Channels: 2, Codec: "aac"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseAudioInfo([]byte(tc.json))
+			if err != nil {
+				t.Fatalf("parseAudioInfo: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("parseAudioInfo = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseAudioInfoErrors(t *testing.T) {
+	for name, json := range map[string]string{
+		"no audio": `{"streams": [{"codec_type": "video", "codec_name": "h264"}], "format": {}}`,
+		"no json":  `Invalid data found when processing input`,
+	} {
+		if info, err := parseAudioInfo([]byte(json)); err == nil {
+			t.Errorf("%s: parseAudioInfo = %+v, want an error", name, info)
+		}
+	}
+}
+
+func TestProbeAudioWithoutFFProbe(t *testing.T) {
+	if info, err := probeAudio("", nil, "song.mp3"); info != nil || err != nil {
+		t.Fatalf("probeAudio without ffprobe = %+v, %v, want nothing", info, err)
+	}
+	if rate := nativeSampleRate(&Config{AudioFile: "song.mp3"}); rate != samplingRate {
+		t.Fatalf("nativeSampleRate without ffprobe = %d, want %d", rate, samplingRate)
+	}
+}
+
+func TestProbeAudio(t *testing.T) {
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		t.Skip("needs ffprobe:", err)
+	}
+	path := filepath.Join(t.TempDir(), "sweep.wav")
+	if err := WriteSweep(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	info, err := probeAudio(ffprobe, nil, path)
+	if err != nil {
+		t.Fatalf("probeAudio: %v", err)
+	}
+	if info.Codec != "pcm_s16le" || info.Channels != 1 || info.SampleRate != samplingRate || info.Duration != 2 {
+		t.Fatalf("probeAudio = %+v, want 2s of mono pcm_s16le at %d", info, samplingRate)
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	for s, want := range map[string]float64{
+		"00:00:05.000000000": 5,
+		"01:02:03.5":         3723.5,
+		"0:00:00.040":        0.04,
+		"":                   0,
+		"N/A":                0,
+	} {
+		if got := parseClock(s); math.Abs(got-want) > 1e-9 {
+			t.Errorf("parseClock(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestParseDurations(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		json         string
+		video, audio float64
+	}{
+		{"mp4", `{"streams": [
+			{"codec_type": "video", "duration": "5.000000"},
+			{"codec_type": "audio", "duration": "5.015510"}
+		]}`, 5, 5.01551},
+		// matroska only has them in the tags
+		{"matroska", `{"streams": [
+			{"codec_type": "video", "tags": {"DURATION": "00:00:05.000000000"}},
+			{"codec_type": "audio", "tags": {"DURATION": "00:00:04.980000000"}}
+		]}`, 5, 4.98},
+		{"first of each", `{"streams": [
+			{"codec_type": "audio", "duration": "2"},
+			{"codec_type": "video", "duration": "3"},
+			{"codec_type": "audio", "duration": "4"},
+			{"codec_type": "video", "duration": "5"}
+		]}`, 3, 2},
+		{"no audio", `{"streams": [{"codec_type": "video", "duration": "1.5"}]}`, 1.5, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			video, audio, err := parseDurations([]byte(tc.json))
+			if err != nil {
+				t.Fatalf("parseDurations: %v", err)
+			}
+			if math.Abs(video-tc.video) > 1e-9 || math.Abs(audio-tc.audio) > 1e-9 {
+				t.Fatalf("parseDurations = %v, %v, want %v, %v", video, audio, tc.video, tc.audio)
+			}
+		})
+	}
+}
+
+// TestShortest renders a sweep that isn't a whole number of frames long,
+// and checks -shortest ended the video and audio together.
+func TestShortest(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("needs ffmpeg:", err)
+	}
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		t.Skip("needs ffprobe:", err)
+	}
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "sweep.wav")
+	if err := WriteSweep(audio, 1.01); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("shortest", flag.ContinueOnError)
+	af, vf, of := AddAudioFlags(fs), AddVisualFlags(fs), AddOutputFlags(fs)
+	if err := fs.Parse([]string{"-audio", audio, "-video", filepath.Join(dir, "out.mkv"), "-width", "160", "-height", "90"}); err != nil {
+		t.Fatal(err)
+	}
+	c := DefaultConfig()
+	for _, apply := range []func(*Config) error{af.Apply, vf.Apply, of.Apply} {
+		if err := apply(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vis, err := NewVisualisation(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RenderVideo(context.Background(), c, vis, nil); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	video, sound, err := StreamDurations(c.FFProbePath, c.VideoFile)
+	if err != nil {
+		t.Fatalf("StreamDurations: %v", err)
+	}
+	if tolerance := 2 / OutputFrameRate(c); math.Abs(video-sound) > tolerance {
+		t.Fatalf("the video is %.3fs long but the audio is %.3fs", video, sound)
+	}
+}
@@ -0,0 +1,59 @@
+package vis
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Profile accumulates how long each stage of the pipeline takes, so we can
+// see whether we are bound by the analysis, the drawing or ffmpeg.
+// A nil *Profile is valid and does nothing, so it can be left unset.
+type Profile struct {
+	started time.Time
+	stages  []string // in the order first seen
+	totals  map[string]time.Duration
+	counts  map[string]int
+}
+
+// NewProfile creates an empty profile, timing the whole run from now
+func NewProfile() *Profile {
+	return &Profile{
+		started: time.Now(),
+		totals:  map[string]time.Duration{},
+		counts:  map[string]int{},
+	}
+}
+
+// Start timing a stage, call the returned func when it is done.
+//
+//	defer p.Start("draw")()
+func (p *Profile) Start(stage string) func() {
+	if p == nil {
+		return func() {}
+	}
+	t := time.Now()
+	return func() {
+		if _, ok := p.totals[stage]; !ok {
+			p.stages = append(p.stages, stage)
+		}
+		p.totals[stage] += time.Since(t)
+		p.counts[stage]++
+	}
+}
+
+// Print the breakdown of the time spent in each stage
+func (p *Profile) Print(w io.Writer) {
+	if p == nil {
+		return
+	}
+	total := time.Since(p.started)
+	fmt.Fprintf(w, "total time: %s\n", total.Round(time.Millisecond))
+	for _, s := range p.stages {
+		d := p.totals[s]
+		fmt.Fprintf(w, "  %-10s %10s %5.1f%% (%s per call)\n",
+			s, d.Round(time.Millisecond), 100*d.Seconds()/total.Seconds(),
+			(d / time.Duration(p.counts[s])).Round(time.Microsecond),
+		)
+	}
+}
@@ -0,0 +1,299 @@
+package vis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoFrames is when the audio gave us nothing to draw
+var ErrNoFrames = errors.New("no frames were rendered; check the audio input")
+
+// RenderVideo renders the audio to the outputs in the config, in two
+// passes if there is a target bitrate. The visualisation can be reused for
+// the next track, see SetTrack. The profile may be nil.
+func RenderVideo(ctx context.Context, config *Config, vis *Visualisation, prof *Profile) error {
+	vis.profile = prof
+	if config.VideoFile != "" && config.AudioFile2 == "" && config.FFProbePath != "" {
+		// copying the audio only works if the container can hold it
+		info, err := probeAudio(config.FFProbePath, config.InputOptions, config.AudioFile)
+		if err != nil {
+			log.Println("Couldn't probe the audio codec, copying it anyway:", err)
+		} else {
+			config.AudioCodecAndOptions = compatibleAudioOptions(info.Codec, outputContainer(config))
+			if config.AudioCodecAndOptions[0] != "copy" {
+				log.Printf("Can't copy %s audio into %s, transcoding with %s", info.Codec, outputContainer(config), config.AudioCodecAndOptions[0])
+			}
+		}
+	}
+
+	var resume *Resume
+	if config.Resume {
+		var err error
+		if resume, err = StartResume(config); err != nil {
+			return err
+		}
+	}
+
+	passes := []int{0}
+	if config.Bitrate != "" {
+		// ffmpeg needs to see all the frames once to hit the bitrate,
+		// so we render everything twice rather than keep the frames.
+		dir, err := os.MkdirTemp("", "visualisation-passlog-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		config.PassLogFile = filepath.Join(dir, "pass")
+		passes = []int{1, 2}
+	}
+
+	for _, pass := range passes {
+		config.Pass = pass
+		if pass > 0 {
+			log.Printf("Encoding pass %d of %d", pass, len(passes))
+		}
+		// every pass has to draw exactly the same frames
+		// (and resuming carries on from the same style)
+		audioFrame, _ := resumePoint(config)
+		vis.Seek(audioFrame * config.OutputFPS / config.FPS)
+		frames, err := renderPass(ctx, config, vis, prof)
+		if err != nil {
+			return err
+		}
+		if resume != nil {
+			// even if we were interrupted again, so the next resume has it all
+			if err := resume.Finish(frames); err != nil {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			// whatever we had was finished off, but there's no more
+			return ctx.Err()
+		}
+		if frames == 0 && resume == nil {
+			return ErrNoFrames
+		}
+	}
+	return nil
+}
+
+// renderPass renders all the audio once (per pass of the encode),
+// the data dump is only written on the last pass.
+func renderPass(ctx context.Context, config *Config, vis *Visualisation, prof *Profile) (int, error) {
+	var video *VideoSink
+	var err error
+	if config.VideoFile != "" {
+		// not tied to ctx, stopping early still finishes the file
+		video, err = NewVideoSink(context.Background(), config)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var dump *DataDump
+	if config.DataFile != "" && config.Pass != 1 {
+		if config.StartFrame > 0 {
+			dump, err = AppendDataDump(config.DataFile)
+		} else {
+			dump, err = NewDataDump(config.DataFile)
+		}
+		if err != nil {
+			if video != nil {
+				video.Finish()
+			}
+			return 0, err
+		}
+	}
+
+	var poster *Poster
+	if config.Poster != "" && config.Pass != 1 {
+		poster = NewPoster(config)
+	}
+
+	process, err := openAudio(ctx, config, prof)
+	if err != nil {
+		// the outputs are no good without it
+		if dump != nil {
+			dump.Close()
+		}
+		if video != nil {
+			video.Finish()
+		}
+		return 0, err
+	}
+
+	var send func(img *image.RGBA) error
+	if video != nil {
+		send = video.SendFrame
+	}
+	frames, err := renderFrames(ctx, config, vis, process, send, nil, dump, poster, prof)
+	if err != nil {
+		return frames, err
+	}
+	if config.SpectrogramFile != "" && config.Pass != 1 && frames > 0 {
+		if err := vis.spectrogram.Save(); err != nil {
+			return frames, err
+		}
+	}
+	if poster != nil && frames > 0 {
+		if err := poster.Save(); err != nil {
+			return frames, err
+		}
+	}
+	if dump != nil {
+		if err := dump.Close(); err != nil {
+			return frames, err
+		}
+	}
+	if video != nil {
+		// let ffmpeg finish writing the file
+		if err := video.Finish(); err != nil {
+			return frames, err
+		}
+	}
+	return frames, nil
+}
+
+// ErrNoFFPlay is previewing without ffplay
+var ErrNoFFPlay = errors.New("Can't find ffplay in path, it is needed to preview")
+
+// Preview plays the visualisation of the audio with ffplay, dropping
+// frames if it can't keep up, and tuning the look from the keyboard. The
+// profile may be nil.
+func Preview(ctx context.Context, config *Config, prof *Profile) error {
+	if config.FFPlayPath == "" {
+		return ErrNoFFPlay
+	}
+	process, err := openAudio(ctx, config, prof)
+	if err != nil {
+		return err
+	}
+	video, err := NewPreviewSink(ctx, config)
+	if err != nil {
+		return err
+	}
+	vis, err := NewVisualisation(config)
+	if err != nil {
+		return err
+	}
+	vis.profile = prof
+	vis.tuner = StartTuner()
+	defer vis.tuner.Stop(vis)
+	if _, err := renderFrames(ctx, config, vis, process, video.SendFrame, video.clock, nil, nil, prof); err != nil {
+		return err
+	}
+	if err := video.Finish(); err != nil {
+		return err
+	}
+	video.clock.Report()
+	return nil
+}
+
+// openAudio starts decoding the audio (both tracks if we are crossfading)
+// and returns the function to process it with.
+func openAudio(ctx context.Context, config *Config, prof *Profile) (func(ctx context.Context, onFrame func(af *AudioFrame) error) error, error) {
+	audio, err := NewAudioSource(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	audio.profile = prof
+	if len(config.Stems) > 0 {
+		var stems []*AudioSource
+		for _, stem := range config.Stems {
+			// the same config, but for the stem
+			sc := *config
+			sc.AudioFile = stem.File
+			s, err := NewAudioSource(ctx, &sc)
+			if err != nil {
+				audio.Stop()
+				for _, s := range stems {
+					s.Stop()
+				}
+				return nil, fmt.Errorf("stem %s: %w", stem.Name, err)
+			}
+			s.profile = prof
+			stems = append(stems, s)
+		}
+		return NewStems(config, audio, stems).StartProcessing, nil
+	}
+	if config.AudioFile2 == "" {
+		return audio.StartProcessing, nil
+	}
+	// the same config, but for the second track
+	c2 := *config
+	c2.AudioFile = config.AudioFile2
+	audio2, err := NewAudioSource(ctx, &c2)
+	if err != nil {
+		audio.Stop()
+		return nil, fmt.Errorf("second audio track: %w", err)
+	}
+	audio2.profile = prof
+	return NewCrossfade(config, audio, audio2).StartProcessing, nil
+}
+
+// renderFrames draws every frame of the audio and sends it to the video
+// (or whatever `send` does with it), the data dump and/or the poster (any
+// may be nil). The clock drops frames for a real-time video, nil for none:
+// a dropped frame is added to the history, but not drawn or sent.
+// It returns how many frames there were. Stopping early, at the frame
+// limit or when the context is cancelled, is not an error: the outputs
+// are still good.
+func renderFrames(ctx context.Context, config *Config, vis *Visualisation, process func(ctx context.Context, onFrame func(af *AudioFrame) error) error, send func(img *image.RGBA) error, clock *FrameClock, dump *DataDump, poster *Poster, prof *Profile) (int, error) {
+	interp := NewFrameInterpolator(config.OutputFPS / config.FPS)
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	// when resuming these frames are already in the outputs
+	_, skip := resumePoint(config)
+
+	frames := 0 // so we can tell if anything happened
+	err := process(ctx, func(af *AudioFrame) error {
+		return interp.Interpolate(af, func(f *AudioFrame) error {
+			if skip > 0 {
+				vis.AddFrame(f)
+				skip--
+				return nil
+			}
+			keep := poster.Wants(config.StartFrame+frames, f)
+			frames++
+			if !keep && clock.Late() {
+				// too slow for real-time, skip this one altogether
+				vis.AddFrame(f)
+			} else if send != nil || keep {
+				img := vis.CreateFrame(f)
+				if keep {
+					poster.Keep(img)
+				}
+				if send != nil {
+					// not before it is due, it is timestamped as it arrives
+					clock.Wait()
+					done := prof.Start("encode")
+					err := send(img)
+					done()
+					if err != nil {
+						return err
+					}
+				}
+			} else {
+				// no need to draw anything
+				vis.AddFrame(f)
+			}
+			if config.MaxFrames > 0 && frames >= config.MaxFrames {
+				// the audio stops at the next frame
+				stop()
+			}
+			if dump != nil {
+				return dump.WriteFrame(vis.Latest())
+			}
+			return nil
+		})
+	})
+	if err == context.Canceled {
+		err = nil
+	}
+	return frames, err
+}
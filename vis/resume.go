@@ -0,0 +1,199 @@
+package vis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Resuming a render carries on from the last frame in the outputs of an
+// interrupted one. The data dump is just appended to, but ffmpeg can't
+// add to an encoded video, so the rest is rendered to a separate part
+// and then the two are joined with the concat demuxer (without encoding
+// them again).
+//
+// The audio starts a few frames before the resume point, so the trail of
+// spectrums is the same as if it hadn't stopped. Those frames are drawn
+// but not output.
+
+// resumePreroll is how many analysis frames before the resume point we
+// start, one for each spectrum in the (longest) trail and one to
+// interpolate from.
+func resumePreroll(c *Config) int {
+	n := historyLength(c)
+	for i := range c.Layers {
+		if l := historyLength(&c.Layers[i]); l > n {
+			n = l
+		}
+	}
+	return n + 1
+}
+
+// Resume is a resumed render, where the rest of the video is going
+type Resume struct {
+	ffmpeg string
+	video  string // the video we are adding to
+	part   string // the video the rest is going to, empty for no video
+}
+
+// StartResume finds the frame the outputs stopped at and sets up the
+// config to carry on from there. It returns nil if there is nothing to
+// resume, so it is a normal render.
+func StartResume(c *Config) (*Resume, error) {
+	frames := -1
+	if c.VideoFile != "" && fileExists(c.VideoFile) {
+		if c.FFProbePath == "" {
+			return nil, errors.New("can't count the frames in the video to resume without ffprobe")
+		}
+		n, err := countVideoFrames(c.FFProbePath, c.VideoFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't count the frames in the video to resume, is it broken? %w", err)
+		}
+		frames = n
+	}
+	if c.DataFile != "" && fileExists(c.DataFile) {
+		n, err := countDumpFrames(c.DataFile)
+		if err != nil {
+			return nil, err
+		}
+		if frames >= 0 && n < frames {
+			// we could only make them the same by cutting the video
+			return nil, fmt.Errorf("the data dump has fewer frames (%d) than the video (%d), can't resume both", n, frames)
+		}
+		if frames < 0 {
+			frames = n
+		}
+		// it may be ahead of the video, or have a partly written frame
+		if err := truncateDump(c.DataFile, frames); err != nil {
+			return nil, err
+		}
+	}
+	if frames <= 0 {
+		return nil, nil
+	}
+
+	log.Printf("Resuming from frame %d", frames)
+	c.StartFrame = frames
+	r := &Resume{ffmpeg: c.FFMpegPath}
+	if c.VideoFile != "" {
+		ext := filepath.Ext(c.VideoFile)
+		r.video = c.VideoFile
+		r.part = strings.TrimSuffix(c.VideoFile, ext) + ".resume" + ext
+		c.VideoFile = r.part
+	}
+	return r, nil
+}
+
+// resumePoint is the analysis frame to start the audio at, and how many
+// (output) frames from there to draw but not output.
+func resumePoint(c *Config) (audioFrame, skip int) {
+	if c.StartFrame == 0 {
+		return 0, 0
+	}
+	steps := c.OutputFPS / c.FPS
+	audioFrame = c.StartFrame/steps - resumePreroll(c)
+	if audioFrame < 0 {
+		audioFrame = 0
+	}
+	return audioFrame, c.StartFrame - audioFrame*steps
+}
+
+// Finish joins the rest of the video (that many frames) on to the end
+// of the first part.
+func (r *Resume) Finish(frames int) error {
+	if r.part == "" {
+		return nil
+	}
+	if frames == 0 {
+		log.Println("Nothing left to render, it was already finished")
+		return os.Remove(r.part)
+	}
+	dir, err := os.MkdirTemp("", "visualisation-resume-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var list bytes.Buffer
+	for _, f := range []string{r.video, r.part} {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return err
+		}
+		// the concat demuxer quotes like the shell
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+	}
+	listFile := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(listFile, list.Bytes(), 0o644); err != nil {
+		return err
+	}
+	joined := filepath.Join(filepath.Dir(r.video), ".joined-"+filepath.Base(r.video))
+	var stderr bytes.Buffer
+	cmd := exec.Command(r.ffmpeg,
+		"-v", "error",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listFile,
+		"-map", "0",
+		"-c", "copy",
+		"-y", joined,
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(joined)
+		return fmt.Errorf("joining the resumed video failed (the rest is in %s): %w\n%s", r.part, err, &stderr)
+	}
+	if err := os.Rename(joined, r.video); err != nil {
+		return err
+	}
+	return os.Remove(r.part)
+}
+
+// countVideoFrames asks ffprobe how many frames the video has
+func countVideoFrames(ffprobe, file string) (int, error) {
+	out, err := exec.Command(ffprobe,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-count_packets",
+		"-show_entries", "stream=nb_read_packets",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		file,
+	).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
+
+// countDumpFrames counts the complete lines in the data dump
+func countDumpFrames(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Count(b, []byte{'\n'}), nil
+}
+
+// truncateDump cuts the data dump down to the first frames lines
+func truncateDump(path string, frames int) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	size := 0
+	for i := 0; i < frames; i++ {
+		size += bytes.IndexByte(b[size:], '\n') + 1
+	}
+	return os.Truncate(path, int64(size))
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,113 @@
+package vis
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumePoint(t *testing.T) {
+	for _, tc := range []struct {
+		start, fps, fpsOut, history int
+		audioFrame, skip            int
+	}{
+		{0, 30, 30, 8, 0, 0},
+		// the trail of 8 and one to interpolate from
+		{100, 30, 30, 8, 91, 9},
+		{100, 30, 60, 8, 41, 18},
+		{101, 30, 60, 8, 41, 19},
+		// too near the start to go back that far
+		{5, 30, 30, 8, 0, 5},
+	} {
+		c := &Config{StartFrame: tc.start, FPS: tc.fps, OutputFPS: tc.fpsOut, History: tc.history}
+		audioFrame, skip := resumePoint(c)
+		if audioFrame != tc.audioFrame || skip != tc.skip {
+			t.Errorf("resumePoint(frame %d, %d/%dfps) = %d, %d, want %d, %d", tc.start, tc.fps, tc.fpsOut, audioFrame, skip, tc.audioFrame, tc.skip)
+		}
+	}
+}
+
+func TestStartResumeDataDump(t *testing.T) {
+	dump := filepath.Join(t.TempDir(), "data.ndjson")
+	// 3 whole frames, and one cut off part way
+	if err := os.WriteFile(dump, []byte("{\"frame\":0}\n{\"frame\":1}\n{\"frame\":2}\n{\"fra"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{DataFile: dump, FPS: 30, OutputFPS: 30}
+	r, err := StartResume(c)
+	if err != nil {
+		t.Fatalf("StartResume: %v", err)
+	}
+	if r == nil || c.StartFrame != 3 {
+		t.Fatalf("StartResume = %v, from frame %d, want to resume from 3", r, c.StartFrame)
+	}
+	b, err := os.ReadFile(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"frame\":0}\n{\"frame\":1}\n{\"frame\":2}\n"; string(b) != want {
+		t.Fatalf("the dump was cut to %q, want %q", b, want)
+	}
+	// there is no video to join on to
+	if err := r.Finish(10); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+}
+
+func TestStartResumeNothing(t *testing.T) {
+	dir := t.TempDir()
+	c := &Config{VideoFile: filepath.Join(dir, "out.mkv"), DataFile: filepath.Join(dir, "data.ndjson")}
+	if r, err := StartResume(c); r != nil || err != nil || c.StartFrame != 0 {
+		t.Fatalf("StartResume without outputs = %v, %v, from frame %d, want a normal render", r, err, c.StartFrame)
+	}
+}
+
+func TestResumeFinishNothingLeft(t *testing.T) {
+	dir := t.TempDir()
+	video, part := filepath.Join(dir, "out.mkv"), filepath.Join(dir, "out.resume.mkv")
+	for _, f := range []string{video, part} {
+		if err := os.WriteFile(f, []byte("video"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := (&Resume{video: video, part: part}).Finish(0); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if fileExists(part) || !fileExists(video) {
+		t.Fatal("the empty part should be gone, and the video left alone")
+	}
+}
+
+func TestResumeFinishJoins(t *testing.T) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("needs ffmpeg:", err)
+	}
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		t.Skip("needs ffprobe:", err)
+	}
+	// a quote in the path, for the concat list's quoting
+	dir := filepath.Join(t.TempDir(), "it's")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	video, part := filepath.Join(dir, "out.mkv"), filepath.Join(dir, "out.resume.mkv")
+	for _, f := range []string{video, part} {
+		out, err := exec.Command(ffmpeg, "-v", "error", "-f", "lavfi", "-i", "testsrc=size=64x64:rate=30",
+			"-frames:v", "15", "-c:v", "ffv1", "-y", f).CombinedOutput()
+		if err != nil {
+			t.Fatalf("making %s: %v\n%s", f, err, out)
+		}
+	}
+	if err := (&Resume{ffmpeg: ffmpeg, video: video, part: part}).Finish(15); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if fileExists(part) {
+		t.Error("the part is still there")
+	}
+	if n, err := countVideoFrames(ffprobe, video); err != nil || n != 30 {
+		t.Errorf("the joined video has %d frames (%v), want 30", n, err)
+	}
+}
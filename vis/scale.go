@@ -0,0 +1,74 @@
+package vis
+
+import "math"
+
+// frequency scales, for the spectrum and the spectrogram
+const (
+	scaleLinear = "linear" // every Hz the same size
+	scaleLog    = "log"    // every octave the same size, like the ear
+	scaleMel    = "mel"    // even steps in pitch, like the ear but roomier for voices (as in speech recognition)
+
+	logLowestHz = 20 // the bottom of the log scale, there's nothing to hear below
+
+	// the points of a log or mel spectrum without '-bands'
+	defaultScaleBands = 128
+)
+
+// frequencyScales are the frequency t (0 to 1) of the way from lo to hi Hz
+var frequencyScales = map[string]func(lo, hi, t float64) float64{
+	scaleLinear: func(lo, hi, t float64) float64 {
+		return lo + (hi-lo)*t
+	},
+	scaleLog: func(lo, hi, t float64) float64 {
+		lo = math.Max(lo, logLowestHz)
+		return lo * math.Pow(hi/lo, t)
+	},
+	scaleMel: func(lo, hi, t float64) float64 {
+		a, b := hzToMel(lo), hzToMel(hi)
+		return melToHz(a + (b-a)*t)
+	},
+}
+
+// hzToMel is the mel (the HTK formula) of a frequency
+func hzToMel(hz float64) float64 {
+	return 2595 * math.Log10(1+hz/700)
+}
+
+// melToHz is the frequency of a mel
+func melToHz(mel float64) float64 {
+	return 700 * (math.Pow(10, mel/2595) - 1)
+}
+
+// scaleBands averages the spectrum into len(dst) bands evenly spaced on
+// the scale from lo to hi Hz, each band the bins between its edges. Where
+// the bands are narrower than the bins (the bass, on a big log scale)
+// there's nothing to average, so it is between the two nearest bins at the
+// band's center instead. Only the first half of freq, the real frequencies,
+// is used.
+func scaleBands(dst, freq []float64, rate int, scale func(lo, hi, t float64) float64, lo, hi float64) {
+	n := len(freq)
+	bin := float64(rate) / float64(n) // Hz
+	m := float64(len(dst))
+	for k := range dst {
+		from := int(math.Ceil(scale(lo, hi, float64(k)/m) / bin))
+		to := int(math.Ceil(scale(lo, hi, float64(k+1)/m) / bin))
+		if to > n/2 {
+			to = n / 2
+		}
+		if from < to {
+			var sum float64
+			for _, x := range freq[from:to] {
+				sum += x
+			}
+			dst[k] = sum / float64(to-from)
+			continue
+		}
+		pos := scale(lo, hi, (float64(k)+0.5)/m) / bin
+		i := int(pos)
+		if i >= n/2-1 {
+			dst[k] = freq[n/2-1]
+			continue
+		}
+		dst[k] = freq[i] + (freq[i+1]-freq[i])*(pos-float64(i))
+	}
+}
@@ -0,0 +1,84 @@
+package vis
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHzToMel(t *testing.T) {
+	for _, tc := range []struct{ hz, mel float64 }{
+		{0, 0},
+		{700, 781.17}, // 2595 log10(2)
+		{1000, 999.99},
+		{4000, 2146.06},
+		{8000, 2840.02},
+	} {
+		if mel := hzToMel(tc.hz); math.Abs(mel-tc.mel) > 0.01 {
+			t.Errorf("hzToMel(%g) = %.3f, want %.2f", tc.hz, mel, tc.mel)
+		}
+		if hz := melToHz(hzToMel(tc.hz)); math.Abs(hz-tc.hz) > 1e-9 {
+			t.Errorf("melToHz(hzToMel(%g)) = %g", tc.hz, hz)
+		}
+	}
+}
+
+func TestScaleEdgesAndCentres(t *testing.T) {
+	for _, tc := range []struct {
+		scale   string
+		lo, hi  float64
+		edges   []float64 // of the bands
+		centres []float64
+	}{
+		// 4 bands, each 710 mels wide
+		{scaleMel, 0, 8000,
+			[]float64{0, 614.33, 1767.79, 3933.55, 8000},
+			[]float64{259.18, 1100.97, 2681.51, 5649.16}},
+		// an octave each, up from the lowest we can hear
+		{scaleLog, 0, 20480,
+			[]float64{20, 40, 80, 160, 320, 640, 1280, 2560, 5120, 10240, 20480},
+			[]float64{20 * math.Sqrt2, 40 * math.Sqrt2, 80 * math.Sqrt2}},
+		{scaleLinear, 100, 500,
+			[]float64{100, 200, 300, 400, 500},
+			[]float64{150, 250, 350, 450}},
+	} {
+		scale := frequencyScales[tc.scale]
+		m := float64(len(tc.edges) - 1)
+		for k, want := range tc.edges {
+			if got := scale(tc.lo, tc.hi, float64(k)/m); math.Abs(got-want) > 0.01 {
+				t.Errorf("%s edge %d = %.2fHz, want %.2f", tc.scale, k, got, want)
+			}
+		}
+		for k, want := range tc.centres {
+			if got := scale(tc.lo, tc.hi, (float64(k)+0.5)/m); math.Abs(got-want) > 0.01 {
+				t.Errorf("%s centre %d = %.2fHz, want %.2f", tc.scale, k, got, want)
+			}
+		}
+	}
+}
+
+func TestScaleBands(t *testing.T) {
+	// 1Hz bins, each the value of its frequency, so a band is the
+	// frequency it reads from
+	freq := make([]float64, 16384)
+	for i := range freq {
+		freq[i] = float64(i)
+	}
+	dst := make([]float64, 4)
+	scaleBands(dst, freq, len(freq), frequencyScales[scaleMel], 0, 8000)
+	// each band averages the bins from its bottom edge up to its top
+	// (rounded up), so 0-614Hz, 615-1767Hz, 1768-3933Hz and 3934-7999Hz
+	for k, want := range []float64{307, 1191, 2850.5, 5966.5} {
+		if math.Abs(dst[k]-want) > 0.01 {
+			t.Errorf("mel band %d = %.2f, want %.2f", k, dst[k], want)
+		}
+	}
+	// bands narrower than a bin are between the bins at their centre
+	freq = freq[:256] // 8000/128 = 62.5Hz bins
+	dst = make([]float64, 128)
+	scaleBands(dst, freq, 16000, frequencyScales[scaleMel], 0, 8000)
+	// the second band is 14-28Hz, between the bins at 0 and 62.5Hz
+	centre := melToHz(hzToMel(8000)*1.5/128) / 62.5
+	if math.Abs(dst[1]-centre) > 1e-9 {
+		t.Errorf("the second mel band = %g, want %g at its centre", dst[1], centre)
+	}
+}
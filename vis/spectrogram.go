@@ -0,0 +1,145 @@
+package vis
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+)
+
+const (
+	spectrogramRange    = 60.0  // dB below the loudest that is still visible
+	spectrogramPeakFall = 0.999 // how much the loudest level falls each frame, so it adapts to the track
+)
+
+// colorMaps turn a magnitude (0 quiet to 1 loud) into a color
+var colorMaps = map[string]func(t float64) color.RGBA{
+	"gray": func(t float64) color.RGBA {
+		g := uint8(math.Round(t * 0xff))
+		return color.RGBA{g, g, g, 0xff}
+	},
+	// black through red and yellow to white
+	"heat": func(t float64) color.RGBA {
+		c := func(x float64) uint8 {
+			return uint8(math.Round(math.Max(0, math.Min(1, x)) * 0xff))
+		}
+		return color.RGBA{c(t * 3), c(t*3 - 1), c(t*3 - 2), 0xff}
+	},
+	// dark blue round to bright red
+	"rainbow": func(t float64) color.RGBA {
+		return hsv(240-240*t, 1, t)
+	},
+}
+
+// Spectrogram is the classic scrolling picture of the spectrum: time
+// along the bottom, frequency up the side and the magnitude as the color.
+// Each frame adds a column on the right, moving the rest to the left.
+type Spectrogram struct {
+	img      *image.RGBA // what is on screen
+	rows     []float64   // the frequency of each row, top to bottom
+	colorMap func(t float64) color.RGBA
+	peak     float64 // the loudest magnitude recently, which is the top of the color map
+	path     string  // where to save the whole track, empty for not at all
+	track    []uint8 // every column so far, if we are saving the whole track
+}
+
+// NewSpectrogram creates the spectrogram for the config
+func NewSpectrogram(c *Config) *Spectrogram {
+	s := &Spectrogram{
+		img:      image.NewRGBA(image.Rect(0, 0, c.Width, c.Height)),
+		rows:     make([]float64, c.Height),
+		colorMap: colorMaps[c.ColorMap],
+		path:     c.SpectrogramFile,
+	}
+	s.Reset()
+	lo, hi := c.MinHz, c.MaxHz
+	if hi == 0 {
+		hi = float64(c.SampleRate) / 2
+	}
+	scale := frequencyScales[c.SpectrogramScale]
+	for y := range s.rows {
+		// the top row is the highest frequency
+		s.rows[y] = scale(lo, hi, 1-float64(y)/float64(c.Height-1))
+	}
+	return s
+}
+
+// Reset clears the spectrogram, for the next track
+func (s *Spectrogram) Reset() {
+	// black, rather than transparent
+	for i := range s.img.Pix {
+		s.img.Pix[i] = 0
+		if i%4 == 3 {
+			s.img.Pix[i] = 0xff
+		}
+	}
+	s.peak = 0
+	s.track = s.track[:0]
+}
+
+// Add scrolls the spectrogram along and draws the frame's spectrum in
+// the new column.
+func (s *Spectrogram) Add(af *AudioFrame) {
+	n := len(af.freq)
+	// the loudest bin scales the colors, but only the first half are real
+	var loudest float64
+	for _, m := range af.freq[:n/2] {
+		loudest = math.Max(loudest, finite(m))
+	}
+	s.peak = math.Max(loudest, s.peak*spectrogramPeakFall)
+
+	w, stride := s.img.Rect.Dx(), s.img.Stride
+	for y, hz := range s.rows {
+		row := s.img.Pix[y*stride : y*stride+w*4]
+		copy(row, row[4:])
+		// between the two nearest bins
+		pos := hz * float64(n) / float64(af.SampleRate())
+		i := int(pos)
+		m := af.freq[i]
+		if i+1 < n/2 {
+			m += (af.freq[i+1] - m) * (pos - float64(i))
+		}
+		col := s.colorMap(s.level(finite(m)))
+		row[len(row)-4], row[len(row)-3], row[len(row)-2], row[len(row)-1] = col.R, col.G, col.B, col.A
+		if s.path != "" {
+			s.track = append(s.track, col.R, col.G, col.B, col.A)
+		}
+	}
+}
+
+// level is where the magnitude is on the color map, from 0 (silent, or
+// spectrogramRange dB below the peak) to 1 (the peak).
+func (s *Spectrogram) level(m float64) float64 {
+	if m <= 0 || s.peak <= 0 {
+		return 0
+	}
+	db := 20 * math.Log10(m/s.peak)
+	return math.Max(0, math.Min(1, 1+db/spectrogramRange))
+}
+
+// Save writes the spectrogram of the whole track (so far) to the file,
+// a column for every frame.
+func (s *Spectrogram) Save() error {
+	h := len(s.rows)
+	cols := len(s.track) / (h * 4)
+	if cols == 0 {
+		return errors.New("no frames for the spectrogram image")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, cols, h))
+	for x := 0; x < cols; x++ {
+		for y := 0; y < h; y++ {
+			copy(img.Pix[y*img.Stride+x*4:], s.track[(x*h+y)*4:(x*h+y)*4+4])
+		}
+	}
+	f, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,97 @@
+package vis
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// Stem is a part of the track (the drums, the bass, the vocals...) as its
+// own audio file, drawn as its own ring.
+type Stem struct {
+	Name  string
+	File  string
+	Color color.RGBA
+}
+
+// parseStem reads a '-stem' as "name=path color=#rrggbb", the color is
+// optional and n'th stem gets the n'th default color without one.
+func parseStem(s string, n int) (Stem, error) {
+	var stem Stem
+	if i := strings.LastIndex(s, " color="); i >= 0 {
+		col, err := parseHexColor(s[i+len(" color="):])
+		if err != nil {
+			return stem, err
+		}
+		stem.Color = col
+		s = s[:i]
+	} else {
+		defaults := spectrumStyles()
+		stem.Color = color.RGBAModel.Convert(defaults[n%len(defaults)].color).(color.RGBA)
+	}
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return stem, fmt.Errorf("%q is not 'name=path'", s)
+	}
+	stem.Name, stem.File = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	return stem, nil
+}
+
+// Stems reads the stems of the track alongside the mix. The mix is what we
+// hear (and what everything else reacts to), each of its frames carries the
+// stems' frames for the same moment, see AudioFrame.Stem. A stem that ends
+// early is silence from there on, and any left after the mix is dropped.
+type Stems struct {
+	mix    *AudioSource
+	stems  []*AudioSource
+	names  []string
+	frames []*AudioFrame
+	done   []bool // the stems that have run out
+}
+
+// NewStems creates the stems reader, the sources are in the config's order
+func NewStems(c *Config, mix *AudioSource, stems []*AudioSource) *Stems {
+	s := &Stems{
+		mix:   mix,
+		stems: stems,
+		done:  make([]bool, len(stems)),
+	}
+	for i, stem := range stems {
+		s.names = append(s.names, c.Stems[i].Name)
+		s.frames = append(s.frames, stem.NewFrame())
+	}
+	return s
+}
+
+// StartProcessing reads the mix and the stems, handing the mix's frames
+// to onFrame in the same way as AudioSource.StartProcessing.
+func (s *Stems) StartProcessing(ctx context.Context, onFrame func(af *AudioFrame) error) error {
+	err := s.mix.StartProcessing(ctx, func(af *AudioFrame) error {
+		for i, stem := range s.stems {
+			if s.done[i] {
+				continue
+			}
+			if err := stem.ReadFrame(s.frames[i]); err == io.EOF {
+				s.done[i] = true
+				clearFrame(s.frames[i])
+			} else if err != nil {
+				return fmt.Errorf("stem %s: %w", s.names[i], err)
+			}
+		}
+		af.stems = s.frames
+		return onFrame(af)
+	})
+	for i, stem := range s.stems {
+		if !s.done[i] || err != nil {
+			// we don't want the rest
+			stem.Stop()
+			continue
+		}
+		if werr := stem.Wait(); werr != nil {
+			err = fmt.Errorf("stem %s: %w", s.names[i], werr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package vis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+// the sweep the self test renders (and the tests use), exponential so
+// each octave takes the same time to cross the spectrum.
+const (
+	sweepFrom      = 50     // Hz
+	sweepTo        = 10_000 // Hz
+	sweepAmplitude = 0.5    // of full scale
+)
+
+// WriteSweep writes the test sweep, a sine wave from the bass to the
+// treble, as a mono 16bit wav file
+func WriteSweep(path string, duration float64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n := int(duration * samplingRate)
+	w := bufio.NewWriter(f)
+	// the canonical 44 byte header
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'}, uint32(36 + 2*n), [4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '}, uint32(16),
+		uint16(1),                // PCM
+		uint16(1),                // mono
+		uint32(samplingRate),     // sample rate
+		uint32(2 * samplingRate), // bytes per second
+		uint16(2),                // bytes per sample
+		uint16(16),               // bits per sample
+		[4]byte{'d', 'a', 't', 'a'}, uint32(2 * n),
+	}
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	// the phase is the integral of the frequency, which goes up
+	// by the same ratio every second.
+	k := math.Log(sweepTo/sweepFrom) / duration
+	for i := 0; i < n; i++ {
+		t := float64(i) / samplingRate
+		phase := 2 * math.Pi * sweepFrom * (math.Exp(k*t) - 1) / k
+		s := int16(sweepAmplitude * math.Sin(phase) * math.MaxInt16)
+		if err := binary.Write(w, binary.LittleEndian, s); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,36 @@
+package vis
+
+import "sync"
+
+// TailBuffer is an io.Writer that only keeps the last `size` bytes written.
+// We use it to capture ffmpeg's stderr, so when something goes wrong we can
+// show the end of the log, which is where the error will be.
+type TailBuffer struct {
+	mu   sync.Mutex // exec copies stderr in another goroutine
+	size int
+	buf  []byte
+}
+
+// NewTailBuffer creates a TailBuffer keeping `size` bytes
+func NewTailBuffer(size int) *TailBuffer {
+	return &TailBuffer{size: size}
+}
+
+// Write implements io.Writer, it never fails
+func (tb *TailBuffer) Write(p []byte) (int, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.buf = append(tb.buf, p...)
+	if over := len(tb.buf) - tb.size; over > 0 {
+		// shift down rather than reslice, so the buffer doesn't grow forever.
+		tb.buf = tb.buf[:copy(tb.buf, tb.buf[over:])]
+	}
+	return len(p), nil
+}
+
+// String returns the captured tail
+func (tb *TailBuffer) String() string {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return string(tb.buf)
+}
@@ -0,0 +1,71 @@
+package vis
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// placeholder is a {name} in an output path
+var placeholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// the placeholders filled from the audio file's tags, if it has them
+var tagPlaceholders = map[string]bool{"title": true, "artist": true, "album": true}
+
+// expandOutput fills the placeholders in an output path from the audio
+// file and the config, so renders of many files (or many settings) don't
+// overwrite each other, e.g. "output/{basename}_{width}x{height}_{fps}fps.mkv"
+// A path without any is returned as it is.
+func expandOutput(path string, c *Config) (string, error) {
+	var tags map[string]string
+	var err error
+	out := placeholder.ReplaceAllStringFunc(path, func(m string) string {
+		name := m[1 : len(m)-1]
+		switch name {
+		case "basename":
+			base := filepath.Base(c.AudioFile)
+			return strings.TrimSuffix(base, filepath.Ext(base))
+		case "width":
+			return strconv.Itoa(c.Width)
+		case "height":
+			return strconv.Itoa(c.Height)
+		case "fps":
+			// 29.97 rather than 29.97002997...
+			return strconv.FormatFloat(math.Round(OutputFrameRate(c)*100)/100, 'f', -1, 64)
+		case "style":
+			return c.Style
+		case "layout":
+			return c.Layout
+		case "palette":
+			return c.Palette
+		case "seed":
+			return strconv.FormatInt(c.Seed, 10)
+		}
+		if !tagPlaceholders[name] {
+			if err == nil {
+				err = fmt.Errorf("unknown placeholder %s", m)
+			}
+			return m
+		}
+		if tags == nil {
+			// only ask ffprobe if we need to
+			tags = map[string]string{}
+			if info, _ := probeAudio(c.FFProbePath, c.InputOptions, c.AudioFile); info != nil {
+				for k, v := range info.Metadata {
+					// the case depends on the container
+					tags[strings.ToLower(k)] = v
+				}
+			}
+		}
+		v := strings.TrimSpace(tags[name])
+		if v == "" {
+			return "unknown"
+		}
+		// a tag mustn't change the directory
+		return strings.NewReplacer("/", "-", `\`, "-").Replace(v)
+	})
+	return out, err
+}
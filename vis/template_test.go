@@ -0,0 +1,70 @@
+package vis
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandOutput(t *testing.T) {
+	c := &Config{
+		AudioFile: "/music/some.track.flac",
+		Width:     1920,
+		Height:    1080,
+		OutputFPS: 30000,
+		FPSDen:    1001,
+		Style:     styleSpectrum,
+		Layout:    "single",
+		Palette:   "random",
+		Seed:      42,
+	}
+	for path, want := range map[string]string{
+		"output/output.mkv":                                 "output/output.mkv",
+		"output/{basename}.mkv":                             "output/some.track.mkv",
+		"{basename}_{width}x{height}_{fps}fps.mp4":          "some.track_1920x1080_29.97fps.mp4",
+		"{style}-{layout}-{palette}-{seed}/{basename}.webm": "spectrum-single-random-42/some.track.webm",
+		// no ffprobe to read the tags with
+		"{artist} - {title}.mkv": "unknown - unknown.mkv",
+	} {
+		got, err := expandOutput(path, c)
+		if err != nil {
+			t.Errorf("expandOutput(%q): %v", path, err)
+		} else if got != want {
+			t.Errorf("expandOutput(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestExpandOutputUnknown(t *testing.T) {
+	got, err := expandOutput("{basename}_{bitrate}.mkv", &Config{AudioFile: "a.mp3"})
+	if err == nil {
+		t.Fatalf("expandOutput with an unknown placeholder = %q, want an error", got)
+	}
+}
+
+func TestExpandOutputTags(t *testing.T) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		t.Skip("needs ffmpeg:", err)
+	}
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		t.Skip("needs ffprobe:", err)
+	}
+	dir := t.TempDir()
+	sweep, tagged := filepath.Join(dir, "sweep.wav"), filepath.Join(dir, "tagged.mka")
+	if err := WriteSweep(sweep, 0.1); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(ffmpeg, "-v", "error", "-i", sweep,
+		"-metadata", "title=AC/DC Live", "-metadata", "artist= Band ", "-y", tagged).CombinedOutput()
+	if err != nil {
+		t.Fatalf("tagging: %v\n%s", err, out)
+	}
+	c := &Config{AudioFile: tagged, FFProbePath: ffprobe}
+	// the slash mustn't make a directory, and there is no album
+	got, err := expandOutput("{artist}/{title} ({album}).mkv", c)
+	if want := "Band/AC-DC Live (unknown).mkv"; err != nil || got != want {
+		t.Fatalf("expandOutput = %q, %v, want %q", got, err, want)
+	}
+}
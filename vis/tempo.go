@@ -0,0 +1,56 @@
+package vis
+
+import "math"
+
+// Tempo moves the visualisation in time with a known BPM, whatever the
+// audio analysis says, for produced tracks where the beat is exact.
+type Tempo struct {
+	bpm    float64 // beats per minute
+	offset float64 // seconds into the track of the first beat
+	fps    float64 // the output frame rate, to turn frames into seconds
+	pulse  float64 // how much bigger the radius is on the beat, as a fraction
+	rotate float64 // degrees the figure turns each beat
+	hue    float64 // degrees the colors turn each beat
+}
+
+// NewTempo is the tempo for the config, or nil if it has no BPM
+func NewTempo(c *Config) *Tempo {
+	if c.BPM <= 0 {
+		return nil
+	}
+	return &Tempo{
+		bpm:    c.BPM,
+		offset: c.BeatOffset,
+		fps:    OutputFrameRate(c),
+		pulse:  c.BeatPulse,
+		rotate: c.BeatRotate,
+		hue:    c.BeatHue,
+	}
+}
+
+// beats is how many beats into the track the frame is, negative
+// before the first one.
+func (t *Tempo) beats(frame int) float64 {
+	return (float64(frame)/t.fps - t.offset) * t.bpm / 60
+}
+
+// radiusScale is what to multiply the radius by on the frame, it jumps
+// up on each beat and eases back down before the next.
+func (t *Tempo) radiusScale(frame int) float64 {
+	b := t.beats(frame)
+	if b < 0 {
+		return 1
+	}
+	fall := 1 - (b - math.Floor(b))
+	return 1 + t.pulse*fall*fall
+}
+
+// turn is how many degrees the figure has turned by the frame
+func (t *Tempo) turn(frame int) float64 {
+	return t.rotate * math.Max(0, t.beats(frame))
+}
+
+// hueShift is how many degrees the colors have turned by the frame
+func (t *Tempo) hueShift(frame int) float64 {
+	return math.Mod(t.hue*math.Max(0, t.beats(frame)), 360)
+}
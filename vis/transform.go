@@ -0,0 +1,21 @@
+package vis
+
+import "github.com/mjibson/go-dsp/fft"
+
+// Transformer is the FFT used for the frequency analysis, so we can swap
+// in (and benchmark) other implementations without touching the maths.
+type Transformer interface {
+	// Transform the real samples into the frequency domain,
+	// returning the same number of values.
+	Transform(x []float64) []complex128
+}
+
+// goDSPTransformer is the default, using github.com/mjibson/go-dsp/fft
+type goDSPTransformer struct{}
+
+// Transform implements Transformer
+func (goDSPTransformer) Transform(x []float64) []complex128 {
+	return fft.FFTReal(x)
+}
+
+var _ Transformer = goDSPTransformer{}
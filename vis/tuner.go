@@ -0,0 +1,123 @@
+package vis
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// the keys that tune the preview, lower case for less and upper for more
+const tunerHelp = "Tune with the keys: h/H height, e/E exponent, s/S smoothing, p to print the flags"
+
+// Tuner reads keys from the terminal while previewing, so the spectrum
+// styles can be tuned while watching. The keys are read in the
+// background, but only applied between frames.
+type Tuner struct {
+	keys chan byte
+}
+
+// StartTuner puts the terminal into cbreak mode (so each key is read as
+// soon as it is pressed) and starts reading. It returns nil if stdin
+// isn't a terminal, which is safe to use and does nothing.
+func StartTuner() *Tuner {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	if err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		// still works, just with enter after each key
+		log.Println("Can't set up the terminal, press enter after each key:", err)
+	}
+	t := &Tuner{keys: make(chan byte, 16)}
+	go func() {
+		b := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(b); err != nil {
+				return
+			}
+			select {
+			case t.keys <- b[0]:
+			default:
+				// mashing the keys faster than the frames
+			}
+		}
+	}()
+	log.Println(tunerHelp)
+	return t
+}
+
+// Apply makes the changes for the keys pressed since the last frame,
+// it is true if anything changed.
+func (t *Tuner) Apply(v *Visualisation) bool {
+	if t == nil {
+		return false
+	}
+	changed := false
+	for {
+		select {
+		case k := <-t.keys:
+			switch k {
+			case 'h':
+				v.heightScale /= 1.1
+			case 'H':
+				v.heightScale *= 1.1
+			case 'e':
+				v.exponentScale -= 0.02
+			case 'E':
+				v.exponentScale += 0.02
+			case 's':
+				if v.smoothingOffset > -maxSmoothing(v) {
+					v.smoothingOffset--
+				}
+			case 'S':
+				v.smoothingOffset++
+			case 'p':
+				log.Println(tunedFlags(v))
+				continue
+			default:
+				continue
+			}
+			if v.exponentScale < 0.02 {
+				v.exponentScale = 0.02
+			}
+			changed = true
+			log.Println(tunedFlags(v))
+		default:
+			return changed
+		}
+	}
+}
+
+// Stop puts the terminal back and prints the flags for the final values,
+// to paste into the render command.
+func (t *Tuner) Stop(v *Visualisation) {
+	if t == nil {
+		return
+	}
+	stty("icanon", "echo")
+	fmt.Println(tunedFlags(v))
+}
+
+// tunedFlags are the flags for what has been tuned
+func tunedFlags(v *Visualisation) string {
+	return fmt.Sprintf("-height-scale %.3g -exponent-scale %.3g -smoothing-offset %d", v.heightScale, v.exponentScale, v.smoothingOffset)
+}
+
+// maxSmoothing is the largest smoothing radius of the styles, below
+// which the offset makes no difference.
+func maxSmoothing(v *Visualisation) int {
+	n := 0
+	for _, s := range v.styles {
+		if s.smoothing > n {
+			n = s.smoothing
+		}
+	}
+	return n
+}
+
+// stty changes the settings of the terminal on stdin
+func stty(args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
@@ -0,0 +1,499 @@
+package vis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// VideoSink is the output file, created by ffmpeg again, that will encode the
+// video we pass into it (our generated visualisation) frame by frame
+type VideoSink struct {
+	Cmd    *exec.Cmd // ffmpeg -i <audio> -i - -f rawvideo -pix_fmt argb -s 1280x720 -r 30 -c:v libx264 <opt>
+	stdin  io.WriteCloser
+	stderr *TailBuffer // only when quiet, otherwise ffmpeg logs to ours
+	player *exec.Cmd   // ffplay, when previewing
+	clock  *FrameClock // paces the preview, nil for a file which never drops frames
+
+	exited  chan struct{} // closed when ffmpeg exits
+	waitErr error         // the result of Cmd.Wait, once exited is closed
+
+	straightAlpha []byte // the frame with the alpha taken out of the colors, when transparent
+}
+
+// NewVideoSink creates the ffmpeg task to read in raw pixel data
+// and encode according to the options. ffmpeg is killed if the context
+// is cancelled, which leaves the file broken, so to stop early and keep
+// the video use Finish instead.
+func NewVideoSink(ctx context.Context, c *Config) (*VideoSink, error) {
+	args := inputArgs(c, false)
+
+	// set output video codec
+	args = append(args, "-c:v")
+	args = append(args, c.VideoCodecAndOptions...)
+	// we send 8bit RGBA, but ffmpeg will convert it to whatever we ask
+	// for, including the 10bit formats (for HDR workflows).
+	if c.PixelFormat != "" {
+		args = append(args, "-pix_fmt", c.PixelFormat)
+	}
+	if c.CodecProfile != "" {
+		args = append(args, "-profile:v", c.CodecProfile)
+	}
+	if c.Bitrate != "" {
+		args = append(args,
+			"-b:v", c.Bitrate,
+			"-pass", strconv.Itoa(c.Pass),
+			"-passlogfile", c.PassLogFile,
+		)
+	}
+	if c.Pass == 1 {
+		// the first pass only writes the log for the second
+		// (the extra options may filter the video, so they count too)
+		args = append(args, "-an")
+		args = append(args, c.ExtraOutputOptions...)
+		args = append(args, "-f", "null", "-")
+		return startSink(c, exec.CommandContext(ctx, c.FFMpegPath, args...))
+	}
+
+	audioOptions := c.AudioCodecAndOptions
+	if c.SafeAudio && c.AudioFile2 == "" && isCopy(audioOptions) && audioCopyFails(c) {
+		// we'd only find out when the mux fails, after we started rendering
+		audioOptions = transcodeAudioOptions(outputContainer(c))
+		log.Printf("Can't copy the audio into %s, transcoding with %s", outputContainer(c), audioOptions[0])
+	}
+	if c.AudioFile2 != "" && isCopy(audioOptions) {
+		// we can't copy filtered audio, it has to be encoded.
+		audioOptions = transcodeAudioOptions(outputContainer(c))
+	}
+
+	// set output audio codec
+	args = append(args, "-c:a")
+	args = append(args, audioOptions...)
+
+	// keep the track's tags (title, artist, ...) from the audio file
+	// and then set any we were asked to.
+	args = append(args, "-map_metadata", "0")
+	for _, kv := range c.Metadata {
+		args = append(args, "-metadata", kv)
+	}
+
+	// the last frame can be short of the audio by a few samples, so cut
+	// the audio there rather than leave a tail of it over a frozen frame.
+	args = append(args, "-shortest")
+	if c.MaxFrames > 0 {
+		// or the audio would carry on after the last frame
+		args = append(args, "-t", strconv.FormatFloat(float64(c.MaxFrames)/OutputFrameRate(c), 'f', -1, 64))
+	}
+	// the muxer is normally guessed from the extension
+	if c.Format != "" {
+		args = append(args, "-f", c.Format)
+	}
+	// last, so they can override anything
+	args = append(args, c.ExtraOutputOptions...)
+	if c.VideoFile == StdoutFile {
+		// the video is all that goes to our stdout
+		cmd := exec.CommandContext(ctx, c.FFMpegPath, append(args, "pipe:1")...)
+		cmd.Stdout = os.Stdout
+		return startSink(c, cmd)
+	}
+	// set output video file (and use `-y` to overwrite)
+	args = append(args, "-y", c.VideoFile)
+	return startSink(c, exec.CommandContext(ctx, c.FFMpegPath, args...))
+}
+
+// NewPreviewSink is a VideoSink that plays the video (and audio) with
+// ffplay instead of saving it. ffplay only takes one input, so ffmpeg
+// muxes them together (without compressing anything) and pipes it over.
+// Both are killed if the context is cancelled.
+func NewPreviewSink(ctx context.Context, c *Config) (*VideoSink, error) {
+	args := inputArgs(c, true)
+	args = append(args,
+		"-c:v", "rawvideo",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "pcm_s16le",
+		"-f", "nut",
+		"-",
+	)
+	cmd := exec.CommandContext(ctx, c.FFMpegPath, args...)
+	player := exec.CommandContext(ctx, c.FFPlayPath,
+		"-loglevel", "error",
+		"-autoexit",
+		"-window_title", "preview: "+filepath.Base(c.AudioFile),
+		"-i", "-",
+	)
+	player.Stderr = os.Stderr
+
+	// ffmpeg writes to ffplay directly, the pipe ends belong to them.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	defer w.Close()
+	cmd.Stdout = w
+	player.Stdin = r
+	if err := player.Start(); err != nil {
+		return nil, err
+	}
+	vs, err := startSink(c, cmd)
+	if err != nil {
+		player.Process.Kill()
+		player.Wait()
+		return nil, err
+	}
+	vs.player = player
+	vs.clock = NewFrameClock(OutputFrameRate(c))
+	return vs, nil
+}
+
+// inputArgs are the ffmpeg inputs for a sink, the audio file(s) and our
+// raw video from stdin, and how to map them to the output. A real-time
+// video can drop frames (see FrameClock), so each frame is timestamped
+// when it arrives rather than by counting them.
+func inputArgs(c *Config, realtime bool) []string {
+	dim := fmt.Sprintf("%dx%d", c.Width, c.Height)
+	args := []string{}
+
+	// how many packets of each input ffmpeg can queue before it blocks
+	queue := strconv.Itoa(c.ThreadQueueSize)
+	if c.StartFrame > 0 {
+		// the audio for the rest of a resumed video
+		args = append(args, "-ss", strconv.FormatFloat(float64(c.StartFrame)/OutputFrameRate(c), 'f', -1, 64))
+	}
+	// audio input file
+	args = append(args, "-thread_queue_size", queue)
+	args = append(args, c.InputOptions...)
+	args = append(args, "-i", c.AudioFile)
+	// stdin for video in raw rgba format.
+	if realtime {
+		args = append(args, "-use_wallclock_as_timestamps", "1")
+	}
+	args = append(args,
+		"-thread_queue_size", queue,
+		"-f", "rawvideo",
+		"-pix_fmt", inputPixelFormat,
+		"-s", dim,
+		"-r", fmt.Sprintf("%d/%d", c.OutputFPS, c.FPSDen),
+		"-i", "-",
+	)
+
+	if c.AudioFile2 != "" {
+		// second audio file, cut the first at the end of the crossfade
+		// and fade into the second, which starts at the crossfade start.
+		end := c.CrossfadeStart + c.CrossfadeDuration
+		args = append(args, c.InputOptions...)
+		args = append(args,
+			"-i", c.AudioFile2,
+			"-filter_complex", fmt.Sprintf(
+				"[0:a]atrim=end=%g[a0];[a0][2:a]acrossfade=d=%g[a]",
+				end, c.CrossfadeDuration,
+			),
+			"-map", "1:v",
+			"-map", "[a]",
+		)
+	}
+	return args
+}
+
+// startSink starts the ffmpeg command, with our frames going to its stdin
+func startSink(c *Config, cmd *exec.Cmd) (*VideoSink, error) {
+	// get a handle on a pipe to stdin
+	var stderr *TailBuffer
+	if c.Quiet {
+		// keep the log to ourselves, unless something goes wrong.
+		stderr = NewTailBuffer(stderrTailSize)
+		cmd.Stderr = stderr
+	} else {
+		if cmd.Stdout == nil {
+			// stdout may be carrying the video, so it's all logging to stderr
+			cmd.Stdout = os.Stderr
+		}
+		cmd.Stderr = os.Stderr
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	// we need to start the process as well.
+	vs := &VideoSink{
+		Cmd:    cmd,
+		stdin:  stdin,
+		stderr: stderr,
+		exited: make(chan struct{}),
+	}
+	if c.Transparent {
+		vs.straightAlpha = make([]byte, c.Width*c.Height*4)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// wait in the background, so we can tell if ffmpeg dies while
+	// we are still sending it frames.
+	go func() {
+		vs.waitErr = cmd.Wait()
+		close(vs.exited)
+	}()
+	return vs, nil
+}
+
+// isCopy is true if the codec options just copy the stream
+func isCopy(options []string) bool {
+	return len(options) == 1 && options[0] == "copy"
+}
+
+// unpremultiply copies the RGBA pixels from src to dst, dividing the
+// colors by the alpha.
+func unpremultiply(dst, src []byte) {
+	for i := 0; i < len(src); i += 4 {
+		a := uint32(src[i+3])
+		switch a {
+		case 0:
+			dst[i], dst[i+1], dst[i+2] = 0, 0, 0
+		case 0xff:
+			dst[i], dst[i+1], dst[i+2] = src[i], src[i+1], src[i+2]
+		default:
+			dst[i] = uint8(uint32(src[i]) * 0xff / a)
+			dst[i+1] = uint8(uint32(src[i+1]) * 0xff / a)
+			dst[i+2] = uint8(uint32(src[i+2]) * 0xff / a)
+		}
+		dst[i+3] = src[i+3]
+	}
+}
+
+// inputPixelFormat is the layout of image.RGBA's Pix, which is what we send
+const inputPixelFormat = "rgba"
+
+// defaultPixelFormats are the output pixel formats for the codecs whose own
+// default (for RGB input) is one that a lot of players can't show.
+var defaultPixelFormats = map[string]string{
+	"libx264": "yuv420p", // otherwise yuv444p, which browsers and QuickTime reject
+	"libx265": "yuv420p",
+}
+
+// outputPixelFormats are the pixel formats we let you ask for,
+// as ffmpeg only tells you it didn't like one after it has started.
+var outputPixelFormats = map[string]bool{
+	"yuv420p":     true,
+	"yuv422p":     true,
+	"yuv444p":     true,
+	"yuv420p10le": true,
+	"yuv422p10le": true,
+	"yuv444p10le": true,
+	"p010le":      true,
+	"gbrp10le":    true,
+	"rgb24":       true,
+	"rgba":        true,
+}
+
+// Finish lets the sink know you are done sending frames
+func (vs *VideoSink) Finish() error {
+	// we are done. close the stdin pipe and let ffmpeg finish
+	vs.stdin.Close()
+	<-vs.exited
+	if err := vs.waitErr; err != nil {
+		if vs.player != nil {
+			vs.player.Process.Kill()
+			vs.player.Wait()
+		}
+		return vs.withLog(fmt.Errorf("ffmpeg video encode failed: %w", err))
+	}
+	if vs.player != nil {
+		// let it play to the end
+		if err := vs.player.Wait(); err != nil {
+			return fmt.Errorf("ffplay failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// withLog adds the end of ffmpeg's log to the error, if we kept it.
+func (vs *VideoSink) withLog(err error) error {
+	if vs.stderr == nil {
+		// it has already been printed
+		return err
+	}
+	return fmt.Errorf("%w\n%s", err, vs.stderr)
+}
+
+// SendFrame sends the data from the image to the buffer.
+// this is image.RGBA as that is what we want to send to FFMPEG
+// TBH as long as the format is compatible with ffmpegs `-pix_fmt`
+// arg and the image type matches we can use it.
+// It may be more performant to use a YUV image type.
+func (vs *VideoSink) SendFrame(img *image.RGBA) error {
+	// this blocks until the data is copied, so we should be OK
+	// as long as the frames are processed in order.
+	// From the RGBA docs:
+	//  > Pix holds the image's pixels, in R, G, B, A order. The pixel at
+	//  > (x, y) starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*4].
+	// But we will assume it's the whole thing.
+	// and we will ensure we write the whole thing or fail.
+	pix := img.Pix
+	if vs.straightAlpha != nil {
+		// image.RGBA is premultiplied, ffmpeg's rgba isn't
+		unpremultiply(vs.straightAlpha, pix)
+		pix = vs.straightAlpha
+	}
+	n := 0
+	var i int
+	var err error
+	backoff := sendFrameMinBackoff
+	for n < len(pix) {
+		i, err = vs.stdin.Write(pix[n:])
+		n += i
+		if err == nil {
+			continue
+		}
+		if !isRetryableWrite(err) {
+			// most likely a broken pipe, because ffmpeg has exited.
+			return vs.exitError(fmt.Errorf("sending frame to ffmpeg: %w", err))
+		}
+		// try again (from where we got to) unless ffmpeg is gone.
+		select {
+		case <-vs.exited:
+			return vs.exitError(fmt.Errorf("sending frame to ffmpeg: %w", err))
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > sendFrameMaxBackoff {
+			backoff = sendFrameMaxBackoff
+		}
+	}
+	return nil
+}
+
+// how long to wait before retrying a failed write
+const (
+	sendFrameMinBackoff = time.Millisecond
+	sendFrameMaxBackoff = 100 * time.Millisecond
+)
+
+// isRetryableWrite is true for the errors that don't mean the pipe is broken
+func isRetryableWrite(err error) bool {
+	return errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, io.ErrShortWrite)
+}
+
+// exitError explains a failed write, with why ffmpeg exited if it has.
+func (vs *VideoSink) exitError(err error) error {
+	select {
+	case <-vs.exited:
+		if vs.waitErr != nil {
+			err = fmt.Errorf("%w (ffmpeg exited: %v)", err, vs.waitErr)
+		}
+	case <-time.After(time.Second):
+		// it is still running (or taking its time to die)
+	}
+	return vs.withLog(err)
+}
+
+// turns out I didn't need this, but we will leave it...
+// I forgot about cmd.StdinPipe(). The VideoSink writes each frame straight
+// to ffmpeg's stdin and does not use a FrameBuffer, so nothing in the
+// render path queues frames through one (the pipe is the only buffer).
+
+// FrameBuffer for reading/writing to from a canvas to the outputstream
+// this allows us to write to a buffer, have it read and then write again to the same buffer.
+// we will use a mutex to easily control read/write
+// basically if read is called before an image is ready we need to signal that we are ready to read
+// i.e. we should write.
+// if we are ready to write a frame, we must wait until the current frame is read.
+// so we start in write mode (we must write the first image before it can be read.)
+// a channel is not the most efficient, but it is certainly the easiest to synchronize
+type FrameBuffer struct {
+	size int
+	r    chan []byte
+	w    chan []byte
+	_r   []byte // the current in progress read buffer
+	_p   int    // the progress through the read buffer.
+}
+
+// NewFrameBuffer creates a new frame buffer with the given frame size,
+// and that many frames of buffers (at least 2, to double buffer). More
+// buffers let a burst of quickly drawn frames queue up while the reader
+// catches up.
+func NewFrameBuffer(size, buffers int) *FrameBuffer {
+	if buffers < 2 {
+		buffers = 2
+	}
+	// every buffer could be in either channel at once
+	r := make(chan []byte, buffers)
+	w := make(chan []byte, buffers)
+
+	// our buffers, all of them start in the write channel
+	for i := 0; i < buffers; i++ {
+		w <- make([]byte, size)
+	}
+
+	return &FrameBuffer{size: size, r: r, w: w}
+}
+
+// WriteFrame writes into a buffer
+func (fb *FrameBuffer) WriteFrame(p []byte) {
+	if len(p) != fb.size {
+		panic("fb.WriteFrame called with mismatched frame size.")
+	}
+	// take a buffer from the write queue
+	b := <-fb.w
+	// copy the data
+	copy(b[:], p)
+	// put it in the read queue
+	fb.r <- b
+}
+
+// Close the buffer, no more writes will work
+func (fb *FrameBuffer) Close() {
+	// if we close this, frames that have been written will still
+	// be processed but no more.
+	close(fb.r)
+	// we don't close the write channel as it complicates
+	// the Read process. so for this framebuffer, it is just
+	// going to silently fail to write frames written after
+	// calling .Close()
+}
+
+// Read to implement io.Reader
+// does this by reading from the read buffer
+func (fb *FrameBuffer) Read(p []byte) (int, error) {
+	l := len(p) // this is how much we want to read
+	n := 0      // this is how much we have read so far
+	for n < l {
+		// are we continueing from a previous frame?
+		if fb._r == nil {
+			// nope, we finished a frame
+			b, ok := <-fb.r // pick the next frame from the channel
+			if !ok {
+				// the channel is closed and empty. we are done
+				return n, io.EOF
+			}
+			fb._r = b
+		}
+		// we should have a buffer in fb._r now.
+		// we copy into p from offset n, from fb._r offset fb._p
+		i := copy(p[n:], fb._r[fb._p:])
+		// advance our pointers.
+		fb._p += i
+		n += i
+		// if we read the full frame, then we much 0 it now.
+		if fb._p == len(fb._r) {
+			// return the buffer to the "write" queue for re-use
+			fb.w <- fb._r
+			fb._r = nil
+			fb._p = 0
+		}
+	}
+	// return how much we read and no error
+	return n, nil
+}
+
+var _ io.Reader = (*FrameBuffer)(nil)
@@ -0,0 +1,150 @@
+package vis
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// frame is a test frame of the size, filled with the value
+func frame(size int, value byte) []byte {
+	return bytes.Repeat([]byte{value}, size)
+}
+
+func TestFrameBufferReadSmallerThanFrame(t *testing.T) {
+	fb := NewFrameBuffer(8, 2)
+	fb.WriteFrame(frame(8, 1))
+	p := make([]byte, 3)
+	for _, want := range []int{3, 3, 2} {
+		n, err := fb.Read(p[:want])
+		if err != nil || n != want {
+			t.Fatalf("Read = %d, %v, want %d, nil", n, err, want)
+		}
+		if !bytes.Equal(p[:n], frame(n, 1)) {
+			t.Fatalf("Read %v, want the frame's bytes", p[:n])
+		}
+	}
+}
+
+func TestFrameBufferReadOneFrame(t *testing.T) {
+	fb := NewFrameBuffer(8, 2)
+	fb.WriteFrame(frame(8, 1))
+	fb.WriteFrame(frame(8, 2))
+	p := make([]byte, 8)
+	for _, value := range []byte{1, 2} {
+		n, err := fb.Read(p)
+		if err != nil || n != 8 {
+			t.Fatalf("Read = %d, %v, want 8, nil", n, err)
+		}
+		if !bytes.Equal(p, frame(8, value)) {
+			t.Fatalf("Read %v, want frame %d", p, value)
+		}
+	}
+}
+
+func TestFrameBufferReadLongerThanFrame(t *testing.T) {
+	fb := NewFrameBuffer(8, 2)
+	fb.WriteFrame(frame(8, 1))
+	fb.WriteFrame(frame(8, 2))
+	p := make([]byte, 12)
+	n, err := fb.Read(p)
+	if err != nil || n != 12 {
+		t.Fatalf("Read = %d, %v, want 12, nil", n, err)
+	}
+	want := append(frame(8, 1), frame(4, 2)...)
+	if !bytes.Equal(p, want) {
+		t.Fatalf("Read %v, want %v", p, want)
+	}
+}
+
+func TestFrameBufferEOFAfterClose(t *testing.T) {
+	fb := NewFrameBuffer(8, 2)
+	fb.WriteFrame(frame(8, 1))
+	fb.Close()
+	p := make([]byte, 12)
+	// what was written before the close is still there
+	n, err := fb.Read(p)
+	if err != io.EOF || n != 8 {
+		t.Fatalf("Read = %d, %v, want 8, io.EOF", n, err)
+	}
+	if !bytes.Equal(p[:n], frame(8, 1)) {
+		t.Fatalf("Read %v, want the frame", p[:n])
+	}
+}
+
+func TestFrameBufferWrapAround(t *testing.T) {
+	// more frames than buffers, so every buffer is reused, and reads
+	// that never line up with the frame boundaries.
+	const size, buffers, frames = 8, 3, 10
+	fb := NewFrameBuffer(size, buffers)
+	go func() {
+		for i := 0; i < frames; i++ {
+			fb.WriteFrame(frame(size, byte(i)))
+		}
+		fb.Close()
+	}()
+	var got []byte
+	p := make([]byte, 5)
+	for {
+		n, err := fb.Read(p)
+		got = append(got, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+	var want []byte
+	for i := 0; i < frames; i++ {
+		want = append(want, frame(size, byte(i))...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Read %v, want %v", got, want)
+	}
+}
+
+func TestFrameBufferQueuesBuffers(t *testing.T) {
+	// with n buffers, n frames can be written before anything is read
+	fb := NewFrameBuffer(4, 4)
+	for i := 0; i < 4; i++ {
+		fb.WriteFrame(frame(4, byte(i)))
+	}
+	// a read spanning three frames hands their buffers back for writing
+	p := make([]byte, 10)
+	if n, err := fb.Read(p); err != nil || n != 10 {
+		t.Fatalf("Read = %d, %v, want 10, nil", n, err)
+	}
+	want := append(append(frame(4, 0), frame(4, 1)...), frame(2, 2)...)
+	if !bytes.Equal(p, want) {
+		t.Fatalf("Read %v, want %v", p, want)
+	}
+	fb.WriteFrame(frame(4, 4))
+	fb.WriteFrame(frame(4, 5))
+	fb.Close()
+	rest, err := io.ReadAll(fb)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	want = append(append(append(frame(2, 2), frame(4, 3)...), frame(4, 4)...), frame(4, 5)...)
+	if !bytes.Equal(rest, want) {
+		t.Fatalf("ReadAll %v, want %v", rest, want)
+	}
+}
+
+func TestFrameBufferReadAfterClose(t *testing.T) {
+	fb := NewFrameBuffer(8, 2)
+	fb.WriteFrame(frame(8, 1))
+	fb.Close()
+	p := make([]byte, 8)
+	// the buffered frame comes out whole, without an error
+	if n, err := fb.Read(p); err != nil || n != 8 {
+		t.Fatalf("Read = %d, %v, want 8, nil", n, err)
+	}
+	// then every read after that is a clean EOF, and does not block
+	for i := 0; i < 3; i++ {
+		if n, err := fb.Read(p); err != io.EOF || n != 0 {
+			t.Fatalf("Read = %d, %v, want 0, io.EOF", n, err)
+		}
+	}
+}
@@ -0,0 +1,1453 @@
+package vis
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/rasterizer"
+)
+
+const (
+	spectrumHeightMultiplier = 8
+	peakHoldWidth            = 2 // the width of the peak hold line
+)
+
+// visualisation styles
+const (
+	styleSpectrum    = "spectrum"    // the frequency analysis (af.freq)
+	styleWaveform    = "waveform"    // the raw samples (af.data), like an oscilloscope
+	styleSpectrogram = "spectrogram" // the spectrum over time, scrolling across the frame
+)
+
+// where the spectrums are drawn
+const (
+	layoutCircular = "circular" // around a circle, like trap nation
+	layoutLinear   = "linear"   // along a line across the middle of the frame
+	// around a circle, the left channel on the left and the right on the right
+	layoutStereoSplit = "stereo-split"
+)
+
+// how each spectrum is drawn
+const (
+	drawFill = "fill" // the area between the curves
+	drawLine = "line" // just the curve at its edge
+)
+
+// lineCaps are the ends of the lines for drawLine
+var lineCaps = map[string]canvas.Capper{
+	"butt":   canvas.ButtCap,
+	"round":  canvas.RoundCap,
+	"square": canvas.SquareCap,
+}
+
+// which way the spectrum grows from the circle
+const (
+	directionOutward = "outward"
+	directionInward  = "inward"
+	directionBoth    = "both"
+)
+
+// how the spectrum is mirrored
+const (
+	symmetryHorizontal = "horizontal" // left and right
+	symmetryVertical   = "vertical"   // top and bottom
+	symmetryBoth       = "both"       // all four ways, like a mandala
+)
+
+// how the spectrums are colored
+const (
+	colorModeAge       = "age"       // by their style, oldest to newest
+	colorModeFrequency = "frequency" // along their length, like a rainbow
+)
+
+// how the spectrum amplitude is clamped
+const (
+	clampNone = "none" // let it go off the edge
+	clampHard = "hard" // flat at the limit
+	clampSoft = "soft" // compressed smoothly up to the limit
+
+	softClampKnee = 0.8 // fraction of the headroom the soft clamp starts at
+	compressKnee  = 0.5 // the width of the compressor's knee, as a fraction of its threshold
+)
+
+// for accessing the [2]float64
+const (
+	X = 0
+	Y = 1
+)
+
+type VisCache struct {
+	raw      []float64
+	smoothed []float64
+	points   [][2]float64 // the outer curve
+	inner    [][2]float64 // the inner curve, bottom to top
+}
+
+func newVisCache(n int) *VisCache {
+	return &VisCache{
+		raw:      make([]float64, n),
+		smoothed: make([]float64, n),
+		points:   make([][2]float64, n),
+		inner:    make([][2]float64, n),
+	}
+}
+
+type Visualisation struct {
+	img               *image.RGBA // the image we will write to and repeatedly output
+	width, height     float64
+	cache             []*VisCache
+	numSpectrums      int // so save having to count all the time
+	frame             int // current frame number
+	style             string
+	bands             int          // 0 means use all the data
+	density           int          // the points for the segment length, 0 for bands
+	opacity           float64      // multiplied into each style's opacity
+	heightScale       float64      // multiplied into each style's height
+	exponentScale     float64      // multiplied into each style's exponent
+	smoothingOffset   int          // added to each style's smoothing radius
+	kernel            string       // overrides each style's smoothing kernel if set
+	direction         string       // one of the direction* constants
+	segments          int          // how many times the spectrum repeats around the circle
+	sides             []float64    // the x multipliers of the halves, -1 mirrors left/right
+	flipY             bool         // mirror everything top/bottom as well
+	layout            string       // one of the layout* constants
+	arcStart          float64      // degrees from the bottom of the circle each half starts at
+	arcSweep          float64      // degrees each half covers
+	colorMode         string       // one of the colorMode* constants
+	clamp             string       // one of the clamp* constants
+	compressThreshold float64      // fraction of the headroom the compressor starts at
+	compressRatio     float64      // how much the compressor squashes the amplitude above the threshold, 1 for none
+	minHz, maxHz      float64      // the frequencies of the spectrum to draw, both 0 for all of it
+	scale             string       // how the frequencies are spread, one of the scale* constants
+	scaled            []float64    // the bands on a log or mel scale, nil for linear
+	reverseFreq       bool         // treble first
+	centroid          float64      // the spectral centroid of the latest frame
+	centroidHue       float64      // degrees to turn the colors at the brightest centroid, 0 to leave them
+	exponentCurve     [][2]float64 // control points of (position, exponent multiplier) along the spectrum
+	exponents         []float64    // the multiplier for each band, from the curve
+	background        *Background  // nil for plain black
+	transparent       bool         // no background at all
+	leadIn            bool         // start with silent spectrums, instead of none
+	strokeWidth       float64      // the outline round each spectrum, 0 for none
+	strokeColor       color.Color
+	lineWidth         float64       // draw each spectrum as a line this wide instead of filling it, 0 to fill
+	lineCap           canvas.Capper // the ends of the lines
+	centerColor       color.Color   // of the circle in the middle
+	centerRing        float64       // the thickness of the circle's ring, 0 for a solid disc
+	gridLevels        []float64     // the reference rings, as fractions of the headroom
+	gridHz            []float64     // the reference lines across the spectrum, in Hz
+	gridColor         color.Color   // of the reference lines
+	sampleRate        int           // of the audio, for where the grid's frequencies are
+	peakDecay         float64       // how much the held peaks fall each frame, 0 for no peak hold
+	peaks             []float64
+	peakPoints        [][2]float64
+	attack, release   float64         // how much of the way to a louder/quieter level each band goes each frame, 1 for all
+	levels            []float64       // the eased level of each band
+	maxAmplitude      float64         // as a fraction of half the shorter side of the frame
+	baseline          float64         // the least height, as a fraction of the radius
+	styles            []SpectrumStyle // spectrumStyles, with the colors from the palette
+	// how the points are joined, one of the interpolations
+	through   func(p *canvas.Path, pts [][2]float64, sx float64)
+	watermark *Watermark
+	bloom     *Bloom           // the glow, nil for none
+	grade     *Grade           // the color adjustments, nil for none
+	layers    []*Visualisation // drawn over this one, in order
+	right     *Visualisation   // the right channel's side for layoutStereoSplit, we are the left
+	stems     []*Visualisation // drawn instead of our spectrums, one ring for each stem
+	tempo     *Tempo           // moves it with the beat, nil for no BPM
+	tuner     *Tuner           // live changes from the keyboard, nil for none
+	// drawn instead of the spectrums for styleSpectrogram
+	spectrogram *Spectrogram
+	resting     bool     // the image is of silence, see idle
+	profile     *Profile // optional timing
+}
+
+// SpectrumStyle slice
+// note that the spectrums are drawn oldest to newest
+// so the colors will be oldest to newest. i.e we want white
+// at the end.
+// also we cannot cache them, because we need to draw them
+// as different sizes... with the exponents....
+type SpectrumStyle struct {
+	color     color.Color
+	exponent  float64
+	smoothing int     // the radius of the smoothing kernel, 0 for none
+	kernel    string  // the smoothing kernel, one of the smoothingKernels
+	opacity   float64 // 0 (invisible) to 1 (solid)
+}
+
+// historyLength is the number of spectrums in the trail
+func historyLength(c *Config) int {
+	if c.History > 0 {
+		return c.History
+	}
+	return len(spectrumStyles())
+}
+
+// historyStyles stretches (or squashes) the spectrumStyles to n, so the
+// trail can be longer than the palette. Each style is interpolated
+// between the two nearest, so the colors become a gradient (mixed in
+// the color space).
+func historyStyles(n int, space string) []SpectrumStyle {
+	defaults := spectrumStyles()
+	if n == len(defaults) {
+		return defaults
+	}
+	styles := make([]SpectrumStyle, n)
+	last := len(defaults) - 1
+	for i := range styles {
+		pos := float64(last)
+		if n > 1 {
+			pos = float64(i) * float64(last) / float64(n-1)
+		}
+		j := int(pos)
+		if j == last {
+			styles[i] = defaults[last]
+			continue
+		}
+		t := pos - float64(j)
+		a, b := defaults[j], defaults[j+1]
+		lerp := func(x, y float64) float64 { return x + (y-x)*t }
+		styles[i] = SpectrumStyle{
+			color: mixColorsIn(space,
+				color.RGBAModel.Convert(a.color).(color.RGBA),
+				color.RGBAModel.Convert(b.color).(color.RGBA),
+				t,
+			),
+			exponent:  lerp(a.exponent, b.exponent),
+			smoothing: int(math.Round(lerp(float64(a.smoothing), float64(b.smoothing)))),
+			kernel:    a.kernel,
+			opacity:   lerp(a.opacity, b.opacity),
+		}
+	}
+	return styles
+}
+
+// notes from js.nation
+// the audiocontext analyser node uses
+// a smoothingTimeContstant
+// min/max Decibels....
+// and the getByteFrequencyData
+// is put into a frequencyBinCount size array.
+//
+
+// spectrumStyles are the default styles, a new slice each time so every
+// visualisation can change its own (there's no state shared between them,
+// so any number can draw at once).
+func spectrumStyles() []SpectrumStyle {
+	return []SpectrumStyle{
+		{
+			color:     color.RGBA{0x00, 0xff, 0x00, 0xff}, // 00ff00ff: green
+			exponent:  1.52,
+			smoothing: 5,
+			kernel:    "triangle",
+			opacity:   1,
+		},
+		{
+			color:     color.RGBA{0x33, 0xcc, 0xff, 0xff}, // 33ccffff: lightblue
+			exponent:  1.50,
+			smoothing: 5,
+			kernel:    "triangle",
+			opacity:   1,
+		},
+		{
+			color:     color.RGBA{0x00, 0x00, 0xff, 0xff}, // 0000ffff: blue
+			exponent:  1.36,
+			smoothing: 3,
+			kernel:    "triangle",
+			opacity:   1,
+		},
+		{
+			color:     color.RGBA{0x33, 0x33, 0x99, 0xff}, // 333399ff: indigo
+			exponent:  1.33,
+			smoothing: 3,
+			kernel:    "triangle",
+			opacity:   1,
+		},
+		{
+			color:     color.RGBA{0xff, 0x66, 0xff, 0xff}, // ff66ffff: pink
+			exponent:  1.30,
+			smoothing: 3,
+			kernel:    "triangle",
+			opacity:   1,
+		},
+		{
+			color:     color.RGBA{0xff, 0x00, 0x00, 0xff}, // ff0000ff: red
+			exponent:  1.14,
+			smoothing: 2,
+			kernel:    "triangle",
+			opacity:   1,
+		},
+		{
+			color:     color.RGBA{0xff, 0xff, 0x00, 0xff}, // ffff00ff: yellow
+			exponent:  1.12,
+			smoothing: 2,
+			kernel:    "triangle",
+			opacity:   1,
+		},
+		{color: color.White,
+			exponent:  1,
+			smoothing: 1,
+			kernel:    "triangle",
+			opacity:   1,
+		},
+	}
+}
+
+func NewVisualisation(c *Config) (*Visualisation, error) {
+	img := image.NewRGBA(image.Rect(0, 0, c.Width, c.Height))
+	n := historyLength(c)
+	v := &Visualisation{
+		img:               img,
+		width:             float64(c.Width),
+		height:            float64(c.Height),
+		cache:             make([]*VisCache, n),
+		numSpectrums:      n,
+		style:             c.Style,
+		bands:             c.Bands,
+		density:           densityPoints(c),
+		opacity:           c.Opacity,
+		heightScale:       c.HeightScale,
+		exponentScale:     c.ExponentScale,
+		smoothingOffset:   c.SmoothingOffset,
+		kernel:            c.SmoothingKernel,
+		direction:         c.Direction,
+		segments:          c.Segments,
+		layout:            c.Layout,
+		arcStart:          c.ArcStart,
+		arcSweep:          c.ArcSweep,
+		colorMode:         c.ColorMode,
+		clamp:             c.Clamp,
+		compressThreshold: c.CompressThreshold,
+		compressRatio:     c.CompressRatio,
+		peakDecay:         c.PeakHold,
+		strokeWidth:       c.StrokeWidth,
+		leadIn:            c.LeadIn,
+		strokeColor:       c.StrokeColor,
+		centerColor:       c.CenterColor,
+		centerRing:        c.CenterRing,
+		gridLevels:        c.GridLevels,
+		gridHz:            c.GridHz,
+		gridColor:         c.GridColor,
+		sampleRate:        c.SampleRate,
+		attack:            easing(c.Attack, OutputFrameRate(c)),
+		release:           easing(c.Release, OutputFrameRate(c)),
+		centroidHue:       c.CentroidHue,
+		exponentCurve:     c.ExponentCurve,
+		minHz:             c.MinHz,
+		maxHz:             c.MaxHz,
+		scale:             c.Scale,
+		reverseFreq:       c.ReverseFreq,
+		maxAmplitude:      c.MaxAmplitude,
+		baseline:          c.Baseline,
+		styles:            make([]SpectrumStyle, n),
+		through:           interpolations[c.Interpolation],
+	}
+	copy(v.styles, historyStyles(n, c.ColorSpace))
+	if c.Palette == paletteRandom {
+		for i, col := range randomPalette(n, c.Seed) {
+			v.styles[i].color = col
+		}
+	}
+	if v.scale != "" && v.scale != scaleLinear {
+		n := defaultScaleBands
+		if v.bands > 0 {
+			n = v.bands
+		} else if v.density > 0 {
+			n = v.density
+		}
+		v.scaled = make([]float64, n)
+	}
+	v.transparent = c.Transparent
+	v.bloom = NewBloom(c)
+	v.grade = NewGrade(c)
+	if c.DrawMode == drawLine {
+		v.lineWidth = c.LineWidth
+		v.lineCap = lineCaps[c.LineCap]
+	}
+	v.tempo = NewTempo(c)
+	if c.Style == styleSpectrogram {
+		v.spectrogram = NewSpectrogram(c)
+	}
+	v.sides = []float64{1, -1}
+	switch c.Symmetry {
+	case symmetryVertical:
+		v.sides = []float64{1}
+		v.flipY = true
+	case symmetryBoth:
+		v.flipY = true
+	}
+	if c.Layout == layoutStereoSplit && c.Style != styleSpectrogram {
+		// a twin keeps the right channel's history, and draws it on
+		// the other side to us.
+		twin := *c
+		twin.Layout = layoutCircular
+		twin.Layers = nil
+		twin.Watermark = ""
+		twin.BackgroundReact = reactNone
+		right, err := NewVisualisation(&twin)
+		if err != nil {
+			return nil, err
+		}
+		right.img = nil // it draws on ours
+		right.sides = []float64{1}
+		v.sides = []float64{-1}
+		v.right = right
+	}
+	for _, stem := range c.Stems {
+		if c.Style == styleSpectrogram {
+			// it has no rings to draw them with
+			break
+		}
+		// a twin for each stem, drawing just its newest spectrum in its color
+		twin := *c
+		twin.Stems = nil
+		twin.Layers = nil
+		twin.Watermark = ""
+		twin.BackgroundReact = reactNone
+		twin.BloomIntensity = 0 // ours glows for everything
+		twin.History = 1
+		twin.Palette = paletteDefault
+		sv, err := NewVisualisation(&twin)
+		if err != nil {
+			return nil, err
+		}
+		sv.img = nil // it draws on ours
+		sv.styles[0].color = stem.Color
+		v.stems = append(v.stems, sv)
+	}
+	if c.BackgroundReact != "" && c.BackgroundReact != reactNone {
+		v.background = &Background{
+			react: c.BackgroundReact,
+			from:  c.BackgroundFrom,
+			to:    c.BackgroundTo,
+		}
+	}
+	for i := range c.Layers {
+		layer, err := NewVisualisation(&c.Layers[i])
+		if err != nil {
+			return nil, err
+		}
+		v.layers = append(v.layers, layer)
+	}
+	if c.Watermark != "" {
+		wm, err := LoadWatermark(c)
+		if err != nil {
+			return nil, err
+		}
+		v.watermark = wm
+	}
+	return v, nil
+}
+
+// Reset forgets all the frames so far, so the next frame is drawn as if
+// it was the first. This lets one Visualisation render several tracks.
+func (v *Visualisation) Reset() {
+	v.frame = 0
+	for i := range v.cache {
+		// reallocated by AddFrame, in case the next track has a different size
+		v.cache[i] = nil
+	}
+	v.peaks = nil
+	v.levels = nil
+	v.resting = false
+	v.centroid = 0
+	if v.background != nil {
+		v.background.level, v.background.peak = 0, 0
+	}
+	if v.spectrogram != nil {
+		v.spectrogram.Reset()
+	}
+	if v.right != nil {
+		v.right.Reset()
+	}
+	for _, stem := range v.stems {
+		stem.Reset()
+	}
+	for _, layer := range v.layers {
+		layer.Reset()
+	}
+}
+
+// SetTrack takes what depends on the track from its config: the tempo
+// ('-bpm auto' finds one for each) and the sample rate ('-native-rate'),
+// which places the grid and the spectrogram's rows. With Reset this lets
+// one Visualisation render several tracks.
+func (v *Visualisation) SetTrack(c *Config) {
+	v.tempo = NewTempo(c)
+	v.sampleRate = c.SampleRate
+	if v.spectrogram != nil {
+		v.spectrogram = NewSpectrogram(c)
+	}
+	if v.right != nil {
+		v.right.SetTrack(c)
+	}
+	for _, stem := range v.stems {
+		stem.SetTrack(c)
+	}
+	for i, layer := range v.layers {
+		layer.SetTrack(&c.Layers[i])
+	}
+}
+
+// Background is a background color that pulses with the audio, between
+// two colors as the loudness (or just the bass) goes from quiet to loud.
+type Background struct {
+	react    string     // one of the react* constants
+	from, to color.RGBA // the quiet and loud colors
+	level    float64    // the latest loudness, 0 to 1
+	peak     float64    // the loudest recently, which is 1
+}
+
+// what the background reacts to
+const (
+	reactNone     = "none"
+	reactLoudness = "loudness" // the whole sound
+	reactBass     = "bass"     // the frequencies below bassCutoff
+
+	bassCutoff          = 150   // Hz
+	backgroundPeakDecay = 0.999 // how much the loudest level falls each frame, so it adapts to the track
+)
+
+// reactToBackground measures the frame for the background color
+func (v *Visualisation) reactToBackground(af *AudioFrame) {
+	bg := v.background
+	if bg == nil {
+		return
+	}
+	var level float64
+	switch bg.react {
+	case reactLoudness:
+		level = af.RMS()
+	case reactBass:
+		// the average magnitude of the bass bins
+		n := int(bassCutoff*float64(len(af.freq))/float64(af.SampleRate())) + 1
+		for _, m := range af.freq[:n] {
+			level += m
+		}
+		level /= float64(n)
+	}
+	level = finite(level)
+	bg.peak = math.Max(level, bg.peak*backgroundPeakDecay)
+	bg.level = 0
+	if bg.peak > 0 {
+		bg.level = level / bg.peak
+	}
+}
+
+// backgroundColor is the color to fill the frame with before the spectrums
+func (v *Visualisation) backgroundColor() color.Color {
+	if v.background == nil {
+		return color.Black
+	}
+	return mixColors(v.background.from, v.background.to, v.background.level)
+}
+
+// Seek resets the Visualisation as if it had already drawn frame-1 frames,
+// so the next frame gets the same style it would have without stopping.
+// Everything drawn with it (the right channel, stems and layers, and
+// theirs) seeks too.
+func (v *Visualisation) Seek(frame int) {
+	v.Reset()
+	v.frame = frame
+	if v.right != nil {
+		v.right.Seek(frame)
+	}
+	for _, stem := range v.stems {
+		stem.Seek(frame)
+	}
+	for _, layer := range v.layers {
+		layer.Seek(frame)
+	}
+}
+
+// AddFrame adds the audio frame to the spectrum history without drawing it.
+func (v *Visualisation) AddFrame(af *AudioFrame) {
+	// pick the data we are drawing
+	// (only the left channel's if the twin draws the right)
+	ch := af
+	if v.right != nil {
+		ch = af.Channel(0)
+		v.right.AddFrame(af.Channel(1))
+	}
+	data := ch.freq
+	if v.style == styleWaveform {
+		data = ch.data
+	} else if v.scaled != nil {
+		hi := v.maxHz
+		if hi == 0 {
+			hi = float64(ch.SampleRate()) / 2
+		}
+		scaleBands(v.scaled, data, ch.SampleRate(), frequencyScales[v.scale], v.minHz, hi)
+		data = v.scaled
+	} else if v.minHz > 0 || v.maxHz > 0 {
+		lo, hi := v.binRange(len(data), ch.SampleRate())
+		data = data[lo:hi]
+	}
+	n := len(data)
+	if v.bands > 0 && v.bands < n {
+		n = v.bands
+	}
+	if v.density > 0 {
+		// interpolated if there aren't enough
+		n = v.density
+	}
+	if v.peaks == nil {
+		v.peaks = make([]float64, n)
+	}
+	if v.frame == 0 && v.leadIn {
+		// start with the whole stack silent, so the ring is there at rest
+		for i := range v.cache {
+			v.cache[i] = newVisCache(n)
+		}
+	}
+	// create the new "spectrum" add it to a stack of them
+	if v.cache[v.frame%v.numSpectrums] == nil {
+		// we need to allocate the next one.
+		v.cache[v.frame%v.numSpectrums] = newVisCache(n)
+	}
+	// copy the current data into the spectrum cache
+	raw := v.cache[v.frame%v.numSpectrums].raw
+	resample(raw, data)
+	if v.reverseFreq && v.style != styleWaveform {
+		// after the cropping and bands, so they still mean the same frequencies
+		reverse(raw)
+	}
+	// a NaN would spread through the smoothing into the neighbours
+	for i, x := range raw {
+		raw[i] = finite(x)
+	}
+	if v.style != styleWaveform {
+		v.ease(raw)
+	}
+	if v.peakDecay > 0 {
+		v.holdPeaks(v.frame % v.numSpectrums)
+	}
+
+	v.centroid = ch.Centroid()
+	v.reactToBackground(af)
+	if v.spectrogram != nil {
+		v.spectrogram.Add(af)
+	}
+
+	//increase the frame number after handling a frame
+	v.frame++
+
+	for i, stem := range v.stems {
+		stem.AddFrame(af.Stem(i))
+	}
+	for _, layer := range v.layers {
+		layer.AddFrame(af)
+	}
+}
+
+// easing is the fraction of the way to a new level to go each frame,
+// so it gets most (1-1/e) of the way there in the given time.
+func easing(seconds, fps float64) float64 {
+	if seconds <= 0 {
+		return 1
+	}
+	return 1 - math.Exp(-1/(seconds*fps))
+}
+
+// ease moves each band's level towards the new one, quickly up (attack) and
+// slowly down (release) like a meter, and replaces the new level with it.
+func (v *Visualisation) ease(raw []float64) {
+	if v.attack == 1 && v.release == 1 {
+		return
+	}
+	if len(v.levels) != len(raw) {
+		// the first frame starts where it is
+		v.levels = append([]float64(nil), raw...)
+		return
+	}
+	for i, x := range raw {
+		k := v.attack
+		if x < v.levels[i] {
+			k = v.release
+		}
+		v.levels[i] += k * (x - v.levels[i])
+		raw[i] = v.levels[i]
+	}
+}
+
+// how quiet the whole stack (and the held peaks) has to be for the frame
+// to be idle, the largest magnitude (or sample): far less than a pixel in
+// any style.
+const idleLevel = 1e-3
+
+// idle is true if there is nothing to see in any of the spectrums, so
+// the frame would look exactly like the last idle one. A background that
+// reacts to the sound might still change, so that is never idle.
+func (v *Visualisation) idle() bool {
+	if v.background != nil || v.tempo != nil || v.spectrogram != nil {
+		return false
+	}
+	for _, cache := range v.cache {
+		if cache == nil {
+			// the stack is still filling up
+			return false
+		}
+		for _, x := range cache.raw {
+			if math.Abs(x) > idleLevel {
+				return false
+			}
+		}
+	}
+	for _, p := range v.peaks {
+		if math.Abs(p) > idleLevel {
+			return false
+		}
+	}
+	if v.right != nil && !v.right.idle() {
+		return false
+	}
+	for _, stem := range v.stems {
+		if !stem.idle() {
+			return false
+		}
+	}
+	for _, layer := range v.layers {
+		if !layer.idle() {
+			return false
+		}
+	}
+	return true
+}
+
+// Latest returns the number of the last frame added and the
+// (downsampled and smoothed) values that will be drawn for it, before
+// the style's height and exponent.
+// The values are reused, so are only valid until the next frame is added.
+func (v *Visualisation) Latest() (int, []float64) {
+	f := v.frame - 1
+	idx := f % v.numSpectrums
+	v.smooth(idx)
+	return f, v.cache[idx].smoothed
+}
+
+// CreateFrame draws a single frame from the audio given.
+func (v *Visualisation) CreateFrame(af *AudioFrame) *image.RGBA {
+	if v.tuner.Apply(v) {
+		// the last frame doesn't look like this one any more
+		v.resting = false
+	}
+	// add the new audioframe
+	v.AddFrame(af)
+	// in a silent passage every frame is the same ring at rest,
+	// so once we have drawn it we can send it again.
+	idle := v.idle()
+	if idle && v.resting {
+		return v.img
+	}
+	v.resting = idle
+	c := canvas.New(v.width, v.height)
+	ctx := canvas.NewContext(c)
+
+	// draw our canvas
+	done := v.profile.Start("draw")
+	v.draw(ctx)
+	done()
+	// dump the data
+	done = v.profile.Start("render")
+	if v.spectrogram != nil {
+		// the layers go on top
+		copy(v.img.Pix, v.spectrogram.img.Pix)
+	} else if v.transparent {
+		// nothing covers up the last frame for us
+		for i := range v.img.Pix {
+			v.img.Pix[i] = 0
+		}
+	}
+	r := rasterizer.New(v.img, 1)
+	c.Render(r)
+	done()
+	if v.bloom != nil {
+		done = v.profile.Start("bloom")
+		v.bloom.Apply(v.img)
+		done()
+	}
+	if v.grade != nil {
+		done = v.profile.Start("grade")
+		v.grade.Apply(v.img)
+		done()
+	}
+	// the watermark goes on top of everything
+	if v.watermark != nil {
+		v.watermark.Draw(v.img)
+	}
+
+	// return the img
+	return v.img
+}
+
+func (v *Visualisation) draw(ctx *canvas.Context) {
+	if v.spectrogram != nil {
+		// it is already on the image, see CreateFrame
+		v.drawLayers(ctx)
+		return
+	}
+	// first fill in black
+	if !v.transparent {
+		ctx.SetFillColor(v.backgroundColor())
+		ctx.DrawPath(0, 0, canvas.Rectangle(v.width, v.height))
+	}
+	halfHeight := v.height / 2
+	halfWidth := v.width / 2
+	// use the smaller side, so it fits in vertical video too
+	radius := math.Min(v.width, v.height) / 4
+	// the frame we are drawing (v.frame has already moved past it)
+	frame := v.frame - 1
+	var turn, hue float64
+	if v.tempo != nil {
+		radius *= v.tempo.radiusScale(frame)
+		turn = v.tempo.turn(frame)
+		hue = v.tempo.hueShift(frame)
+	}
+	// how far the spectrum can go before it is clamped
+	headroom := v.maxAmplitude*math.Min(v.width, v.height)/2 - radius
+	if v.direction == directionInward {
+		headroom = radius
+	}
+	linear := v.layout == layoutLinear
+	if linear {
+		// the "radius" is just the distance to the center line, far
+		// enough that inward spectrums don't hit zero.
+		headroom = v.maxAmplitude * halfHeight
+		radius = headroom
+	}
+
+	if v.direction != directionOutward && !linear {
+		// the spectrums go inside the circle so it must be drawn first
+		v.drawCenter(ctx, halfWidth, halfHeight, radius)
+	}
+
+	// now draw a path around the circle in the shape of a spectrum analyser.
+	// so polar cordinates for the points based on volume at frequency.
+	// and mirror the path on both sides of the circle.
+	// with more than one segment, each (mirrored) spectrum only covers its
+	// share of the circle and is repeated around it like a kaleidoscope.
+	// the arc is measured from the bottom of the circle (where the mirror
+	// joins) so by default each half goes all the way from bottom to top.
+	start := v.arcStart*math.Pi/180 - math.Pi/2
+	sweep := v.arcSweep * math.Pi / 180 / float64(v.segments)
+	// pos is the point f (0 to 1) of the way along the spectrum, at r from the center
+	pos := func(f, r float64) [2]float64 {
+		t := start + sweep*f
+		return [2]float64{r * math.Cos(t), r * math.Sin(t)}
+	}
+	segments := v.segments
+	if linear {
+		// the bass in the middle, out to the treble at the sides,
+		// above (and/or below) the center line.
+		pos = func(f, r float64) [2]float64 {
+			return [2]float64{f * halfWidth, r - radius}
+		}
+		segments = 1
+		turn = 0
+	}
+	// fill the path in the current fill color, once for every segment.
+	// (turned with the beat, if there is one)
+	fill := func(p *canvas.Path) {
+		if v.flipY {
+			p = p.Copy().Append(p.Copy().Transform(canvas.Identity.Scale(1, -1)))
+		}
+		if turn == 0 {
+			ctx.DrawPath(halfWidth, halfHeight, p)
+		} else {
+			ctx.DrawPath(halfWidth, halfHeight, p.Copy().Transform(canvas.Identity.Rotate(turn)))
+		}
+		for k := 1; k < segments; k++ {
+			rot := canvas.Identity.Rotate(turn + float64(k)*360/float64(segments))
+			ctx.DrawPath(halfWidth, halfHeight, p.Copy().Transform(rot))
+		}
+	}
+	// the grid goes behind the spectrums (but over the circle if they are inside it)
+	v.drawGrid(ctx, radius, headroom, pos, fill)
+	if v.right != nil {
+		v.right.drawGrid(ctx, radius, headroom, pos, fill)
+	}
+	// the stems' rings replace ours, the mix is only what we hear
+	spectrums := []*Visualisation{v}
+	if len(v.stems) > 0 {
+		spectrums = v.stems
+	}
+	for _, s := range spectrums {
+		// with the same live tweaks
+		s.heightScale, s.exponentScale, s.smoothingOffset = v.heightScale, v.exponentScale, v.smoothingOffset
+		s.drawSpectrums(ctx, radius, headroom, hue, pos, fill)
+		if s.right != nil {
+			// the right channel on the other side
+			s.right.heightScale, s.right.exponentScale, s.right.smoothingOffset = v.heightScale, v.exponentScale, v.smoothingOffset
+			s.right.drawSpectrums(ctx, radius, headroom, hue, pos, fill)
+		}
+	}
+
+	// then lets draw a circle in the middle
+	if v.direction == directionOutward && !linear {
+		v.drawCenter(ctx, halfWidth, halfHeight, radius)
+	}
+
+	v.drawLayers(ctx)
+}
+
+// drawSpectrums draws the stack of spectrums, and the held peaks, on our
+// sides of the circle. fill draws a path everywhere it repeats.
+func (v *Visualisation) drawSpectrums(ctx *canvas.Context, radius, headroom, hue float64, pos func(f, r float64) [2]float64, fill func(p *canvas.Path)) {
+	if v.lineWidth > 0 {
+		// just the edges, in each spectrum's color
+		ctx.SetFillColor(color.Transparent)
+		ctx.SetStrokeWidth(v.lineWidth)
+		ctx.SetStrokeCapper(v.lineCap)
+	} else if v.strokeWidth > 0 {
+		// everything we fill gets outlined, until the spectrums are done
+		ctx.SetStrokeColor(v.strokeColor)
+		ctx.SetStrokeWidth(v.strokeWidth)
+	}
+	for s := 0; s < v.numSpectrums; s++ {
+		// this is the number of the frame numSpectrums-1 ago + s
+		// (v.frame has already moved past the current frame)
+		x := v.frame - v.numSpectrums + s
+		if x < 0 {
+			// we don't have these frames just yet we must be starting
+			if !v.leadIn {
+				continue
+			}
+			// so draw the silence the stack started with
+			x += v.numSpectrums
+		}
+		// to draw the spectrum we must first create all the points.
+		// we use the pointsCache for this to save allocation every frame
+		// style like `s`
+
+		idx := x % v.numSpectrums
+		style := v.styles[idx]
+		cache := v.cache[idx]
+		if cache == nil {
+			// or we started part way through (see Seek)
+			continue
+		}
+		// again, as the tuner may have changed the smoothing
+		v.smooth(idx)
+		// now create all the x/y co-ordinates.
+		// each spectrum is the area between the outer and inner curves.
+		l := len(cache.points)
+		for i := 0; i < l; i++ {
+			f := float64(i) / float64(l-1)
+			a := v.amplitude(cache.smoothed[i], style.exponent, i, l, radius, headroom)
+			outer, inner := radius+a, radius
+			switch v.direction {
+			case directionInward:
+				outer, inner = radius, math.Max(0, radius-a)
+			case directionBoth:
+				outer, inner = radius+a, math.Max(0, radius-a)
+			}
+
+			cache.points[i] = pos(f, outer)
+			// the inner curve is stored backwards, as we draw it
+			// from the bottom back up to the top.
+			cache.inner[l-1-i] = pos(f, inner)
+		}
+
+		opacity := style.opacity * v.opacity
+		if v.colorMode == colorModeFrequency {
+			// every band is its own color, so its own path.
+			for j := 0; j < l-1; j++ {
+				// red for the bass round to violet for the treble
+				col := withOpacity(hsv(270*float64(j)/float64(l-1)+hue, 1, 1), opacity)
+				if v.lineWidth > 0 {
+					ctx.SetStrokeColor(col)
+					fill(v.bandLine(cache, j))
+					continue
+				}
+				ctx.SetFillColor(col)
+				fill(bandPath(cache, j, v.sides))
+			}
+			continue
+		}
+
+		col := style.color
+		if v.centroidHue != 0 || hue != 0 {
+			col = rotateHue(col, v.centroidHue*v.brightness()+hue)
+		}
+		if v.lineWidth > 0 {
+			ctx.SetStrokeColor(withOpacity(col, opacity))
+			fill(v.linePath(cache))
+			continue
+		}
+		// now we can make the path and draw
+		p := &canvas.Path{}
+		// one side, then the other side mirrored.
+		for _, sx := range v.sides {
+			// the top of the circle (or the height of the first point above the top)
+			p.MoveTo(sx*cache.points[0][X], cache.points[0][Y])
+			v.through(p, cache.points, sx)
+			// across to the inner curve and back up to the top.
+			p.LineTo(sx*cache.inner[0][X], cache.inner[0][Y])
+			v.through(p, cache.inner, sx)
+			p.Close()
+		}
+		// let's draw this!
+		ctx.SetFillColor(withOpacity(col, opacity))
+		fill(p)
+	}
+
+	ctx.SetStrokeCapper(canvas.ButtCap)
+	ctx.SetStrokeColor(color.Transparent)
+	ctx.SetStrokeWidth(0)
+
+	if v.peakDecay > 0 {
+		v.drawPeaks(ctx, radius, headroom, pos, fill)
+	}
+}
+
+// amplitude is how far from the radius band i of l is drawn, for its
+// (smoothed) magnitude m with the exponent of its spectrum's style.
+func (v *Visualisation) amplitude(m, exponent float64, i, l int, radius, headroom float64) float64 {
+	// the waveform goes negative, so keep the sign out of the exponent
+	m *= spectrumHeightMultiplier * v.heightScale
+	// anything that overflowed draws nothing rather than breaking the path
+	a := finite(math.Copysign(math.Pow(math.Abs(m), exponent*v.exponentScale*v.exponentAt(i, l)), m))
+	if v.style != styleWaveform {
+		// silence still shows a ring, the magnitudes only add to it
+		a = math.Max(a, 0) + v.baseline*radius
+	}
+	return math.Copysign(v.clampAmplitude(v.compress(math.Abs(a), headroom), headroom), a)
+}
+
+// smooth smooths spectrum idx of the stack with its style's kernel
+func (v *Visualisation) smooth(idx int) {
+	style := v.styles[idx]
+	kernel := style.kernel
+	if v.kernel != "" {
+		kernel = v.kernel
+	}
+	smoothing := style.smoothing + v.smoothingOffset
+	if smoothing < 0 {
+		smoothing = 0
+	}
+	v.doSmoothing(v.cache[idx], kernel, smoothing)
+}
+
+// holdPeaks lets the held peaks fall a frame, and spectrum idx (the one
+// just added) push them back up. They are the smoothed magnitudes, so
+// whether a frame is drawn or not they move the same.
+func (v *Visualisation) holdPeaks(idx int) {
+	v.smooth(idx)
+	for i, m := range v.cache[idx].smoothed {
+		// huge magnitudes can overflow in the smoothing
+		v.peaks[i] = math.Max(finite(m), v.peaks[i]*(1-v.peakDecay))
+	}
+}
+
+// drawCenter draws the circle in the middle: a disc, or just a ring round
+// the edge so whatever is behind shows through.
+func (v *Visualisation) drawCenter(ctx *canvas.Context, x, y, radius float64) {
+	if v.centerRing <= 0 {
+		ctx.SetFillColor(v.centerColor)
+		ctx.DrawPath(x, y, canvas.Circle(radius))
+		return
+	}
+	// the stroke is either side of the path, so its outside is the radius
+	ctx.SetFillColor(color.Transparent)
+	ctx.SetStrokeColor(v.centerColor)
+	ctx.SetStrokeWidth(v.centerRing)
+	ctx.DrawPath(x, y, canvas.Circle(math.Max(radius-v.centerRing/2, 0)))
+	ctx.SetStrokeColor(color.Transparent)
+	ctx.SetStrokeWidth(0)
+}
+
+// drawLayers draws the layers on top, in order
+func (v *Visualisation) drawLayers(ctx *canvas.Context) {
+	for _, layer := range v.layers {
+		layer.draw(ctx)
+	}
+}
+
+// drawPeaks draws the held peaks as a thin line over the spectrums, as
+// high as the newest spectrum would be drawn for them.
+func (v *Visualisation) drawPeaks(ctx *canvas.Context, radius, headroom float64, pos func(f, r float64) [2]float64, fill func(p *canvas.Path)) {
+	l := len(v.peaks)
+	if l < 2 || v.frame == 0 {
+		// no line to draw (or nothing to hold yet)
+		return
+	}
+	if len(v.peakPoints) != l {
+		v.peakPoints = make([][2]float64, l)
+	}
+	style := v.styles[(v.frame-1)%v.numSpectrums]
+	for i, m := range v.peaks {
+		a := v.amplitude(m, style.exponent, i, l, radius, headroom)
+		r := radius + a
+		if v.direction == directionInward {
+			r = math.Max(0, radius-a)
+		}
+		v.peakPoints[i] = pos(float64(i)/float64(l-1), r)
+	}
+	p := &canvas.Path{}
+	for _, sx := range v.sides {
+		p.MoveTo(sx*v.peakPoints[0][X], v.peakPoints[0][Y])
+		v.through(p, v.peakPoints, sx)
+	}
+	// just the line, no fill
+	ctx.SetFillColor(color.Transparent)
+	ctx.SetStrokeColor(color.White)
+	ctx.SetStrokeWidth(peakHoldWidth)
+	fill(p)
+	ctx.SetStrokeColor(color.Transparent)
+	ctx.SetStrokeWidth(0)
+}
+
+// exponentAt is how much to multiply the style's exponent by for band i
+// of l, linearly interpolated between the points of the exponent curve.
+func (v *Visualisation) exponentAt(i, l int) float64 {
+	if len(v.exponentCurve) == 0 {
+		return 1
+	}
+	if len(v.exponents) != l {
+		// the number of bands doesn't change, so work them all out once
+		v.exponents = make([]float64, l)
+		for j := range v.exponents {
+			v.exponents[j] = curveAt(v.exponentCurve, float64(j)/float64(l-1))
+		}
+		if v.reverseFreq {
+			// the curve is bass to treble whichever way it is drawn
+			reverse(v.exponents)
+		}
+	}
+	return v.exponents[i]
+}
+
+// curveAt interpolates the curve (points sorted by X) at x, the ends
+// carry on flat.
+func curveAt(curve [][2]float64, x float64) float64 {
+	if x <= curve[0][X] {
+		return curve[0][Y]
+	}
+	for k := 1; k < len(curve); k++ {
+		if x <= curve[k][X] {
+			a, b := curve[k-1], curve[k]
+			return a[Y] + (b[Y]-a[Y])*(x-a[X])/(b[X]-a[X])
+		}
+	}
+	return curve[len(curve)-1][Y]
+}
+
+// compress is a soft knee compressor on the amplitude: above the threshold
+// (a fraction of the headroom) it only grows by 1/ratio, easing into that
+// over the knee so there's no corner. Quiet detail is untouched while the
+// loud peaks are tamed.
+func (v *Visualisation) compress(a, headroom float64) float64 {
+	if v.compressRatio <= 1 {
+		return a
+	}
+	t := v.compressThreshold * headroom
+	w := t * compressKnee
+	switch {
+	case a <= t-w/2:
+		return a
+	case a >= t+w/2:
+		return t + (a-t)/v.compressRatio
+	}
+	// the quadratic between the two lines
+	d := a - (t - w/2)
+	return a + (1/v.compressRatio-1)*d*d/(2*w)
+}
+
+// clampAmplitude limits the amplitude to the headroom, so loud peaks
+// flatten out rather than being cut off by the edge of the frame.
+// the soft clamp starts to compress them a little before the limit.
+func (v *Visualisation) clampAmplitude(a, headroom float64) float64 {
+	switch v.clamp {
+	case clampHard:
+		return math.Min(a, headroom)
+	case clampSoft:
+		knee := headroom * softClampKnee
+		if a <= knee {
+			return a
+		}
+		// tanh eases the rest of the way up to the headroom
+		return knee + (headroom-knee)*math.Tanh((a-knee)/(headroom-knee))
+	}
+	return a
+}
+
+// bandPath is the (mirrored) area of the spectrum between point j and j+1
+// it uses straight lines, but with enough bands you can't tell.
+func bandPath(cache *VisCache, j int, sides []float64) *canvas.Path {
+	// remember the inner points are backwards
+	l := len(cache.points)
+	o0, o1 := cache.points[j], cache.points[j+1]
+	i0, i1 := cache.inner[l-1-j], cache.inner[l-2-j]
+	p := &canvas.Path{}
+	for _, sx := range sides {
+		p.MoveTo(sx*o0[X], o0[Y])
+		p.LineTo(sx*o1[X], o1[Y])
+		p.LineTo(sx*i1[X], i1[Y])
+		p.LineTo(sx*i0[X], i0[Y])
+		p.Close()
+	}
+	return p
+}
+
+// linePath is the open curve along the edge of the spectrum that moves,
+// the outer one and/or the inner one depending on the direction.
+func (v *Visualisation) linePath(cache *VisCache) *canvas.Path {
+	p := &canvas.Path{}
+	for _, sx := range v.sides {
+		if v.direction != directionInward {
+			p.MoveTo(sx*cache.points[0][X], cache.points[0][Y])
+			v.through(p, cache.points, sx)
+		}
+		if v.direction != directionOutward {
+			p.MoveTo(sx*cache.inner[0][X], cache.inner[0][Y])
+			v.through(p, cache.inner, sx)
+		}
+	}
+	return p
+}
+
+// bandLine is the linePath of just the band between point j and j+1
+func (v *Visualisation) bandLine(cache *VisCache, j int) *canvas.Path {
+	// remember the inner points are backwards
+	l := len(cache.points)
+	o0, o1 := cache.points[j], cache.points[j+1]
+	i0, i1 := cache.inner[l-1-j], cache.inner[l-2-j]
+	p := &canvas.Path{}
+	for _, sx := range v.sides {
+		if v.direction != directionInward {
+			p.MoveTo(sx*o0[X], o0[Y])
+			p.LineTo(sx*o1[X], o1[Y])
+		}
+		if v.direction != directionOutward {
+			p.MoveTo(sx*i0[X], i0[Y])
+			p.LineTo(sx*i1[X], i1[Y])
+		}
+	}
+	return p
+}
+
+// interpolations are the ways to join up the points of the spectrum
+var interpolations = map[string]func(p *canvas.Path, pts [][2]float64, sx float64){
+	"linear": lineThrough,  // straight lines, sharp and spiky
+	"quad":   quadThrough,  // quadratic curves between the midpoints
+	"cubic":  cubicThrough, // catmull-rom through every point, the smoothest
+}
+
+// lineThrough continues the path through the points with straight lines.
+// The path must already be at the first point.
+func lineThrough(p *canvas.Path, pts [][2]float64, sx float64) {
+	for _, pt := range pts[1:] {
+		p.LineTo(sx*pt[X], pt[Y])
+	}
+}
+
+// cubicThrough continues the path through the points with a catmull-rom
+// spline, as cubic curves. Unlike quadThrough the curve passes through
+// every point. The path must already be at the first point.
+func cubicThrough(p *canvas.Path, pts [][2]float64, sx float64) {
+	l := len(pts)
+	for j := 0; j < l-1; j++ {
+		// the ends use themselves as the missing neighbour
+		p0, p1, p2, p3 := pts[j], pts[j], pts[j+1], pts[j+1]
+		if j > 0 {
+			p0 = pts[j-1]
+		}
+		if j < l-2 {
+			p3 = pts[j+2]
+		}
+		p.CubeTo(
+			sx*(p1[X]+(p2[X]-p0[X])/6), p1[Y]+(p2[Y]-p0[Y])/6,
+			sx*(p2[X]-(p3[X]-p1[X])/6), p2[Y]-(p3[Y]-p1[Y])/6,
+			sx*p2[X], p2[Y],
+		)
+	}
+}
+
+// quadThrough continues the path through the points with quadratic curves,
+// using the midpoints between them as the ends of each curve so it is smooth.
+// The path must already be at the first point. sx is -1 to mirror the
+// points to the other side of the circle.
+func quadThrough(p *canvas.Path, pts [][2]float64, sx float64) {
+	l := len(pts)
+	for j := 1; j < l-2; j++ {
+		p.QuadTo(
+			sx*pts[j][X], pts[j][Y],
+			sx*(pts[j][X]+pts[j+1][X])/2,
+			(pts[j][Y]+pts[j+1][Y])/2,
+		)
+	}
+	// finally the curve to the final point.
+	p.QuadTo(
+		sx*pts[l-2][X],
+		pts[l-2][Y],
+		sx*pts[l-1][X],
+		pts[l-1][Y],
+	)
+}
+
+// smoothingKernels give the weight of a point `k` away from the
+// one being smoothed, for a kernel of radius `r`.
+var smoothingKernels = map[string]func(k, r int) float64{
+	// every point in the radius counts the same
+	"box": func(k, r int) float64 {
+		return 1
+	},
+	// points count less the further away they are
+	"triangle": func(k, r int) float64 {
+		return float64(r + 1 - k)
+	},
+	// a bell curve, with the radius at 2 standard deviations.
+	"gaussian": func(k, r int) float64 {
+		sigma := float64(r) / 2
+		return math.Exp(-float64(k*k) / (2 * sigma * sigma))
+	},
+}
+
+// doSmoothing is a weighted average of the points within `margin` of each
+// point, using the kernel's weights. At the edges the points that would be
+// off the end are skipped and the weights of the rest are scaled up.
+func (v *Visualisation) doSmoothing(cache *VisCache, kernel string, margin int) {
+	weight, ok := smoothingKernels[kernel]
+	if !ok || margin < 1 {
+		copy(cache.smoothed, cache.raw)
+		return
+	}
+	l := len(cache.raw)
+	for i := 0; i < l; i++ {
+		var sum, denom float64
+		for j := i - margin; j <= i+margin; j++ {
+			if j < 0 || j > l-1 {
+				continue
+			}
+			w := weight(int(math.Abs(float64(i-j))), margin)
+			sum += cache.raw[j] * w
+			denom += w
+		}
+		cache.smoothed[i] = sum / denom
+	}
+}
+
+// binRange is the FFT bins (of n) between minHz and maxHz. The bins are
+// rate/n Hz apart, and only the first half are real frequencies,
+// the rest is the mirror image.
+func (v *Visualisation) binRange(n, rate int) (lo, hi int) {
+	lo = int(math.Ceil(v.minHz * float64(n) / float64(rate)))
+	hi = n / 2
+	if v.maxHz > 0 {
+		hi = int(v.maxHz*float64(n)/float64(rate)) + 1
+	}
+	if hi > n/2 {
+		hi = n / 2
+	}
+	if hi-lo < 2 {
+		// too narrow for the FFT size, draw what we can
+		lo = hi - 2
+		if lo < 0 {
+			lo, hi = 0, 2
+		}
+	}
+	return lo, hi
+}
+
+// the range of spectral centroids brightness maps from 0 to 1 (in Hz),
+// music is mostly in the middle of it.
+const (
+	dullCentroid   = 200
+	brightCentroid = 8000
+)
+
+// brightness is where the latest spectral centroid is between dull and
+// bright, on a log scale like the ear, from 0 to 1.
+func (v *Visualisation) brightness() float64 {
+	if v.centroid <= dullCentroid {
+		return 0
+	}
+	b := math.Log(v.centroid/dullCentroid) / math.Log(brightCentroid/dullCentroid)
+	return math.Min(1, b)
+}
+
+// finite is x, or 0 if x is NaN or infinite. The rasterizer can't cope
+// with them in the path.
+func finite(x float64) float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return 0
+	}
+	return x
+}
+
+// densityPoints is how many points put each (mirrored) spectrum about
+// SegmentLength pixels apart, round the circle or across the frame.
+// 0 if there is no segment length.
+func densityPoints(c *Config) int {
+	if c.SegmentLength <= 0 {
+		return 0
+	}
+	length := float64(c.Width) / 2
+	if c.Layout != layoutLinear {
+		radius := math.Min(float64(c.Width), float64(c.Height)) / 4
+		length = radius * c.ArcSweep * math.Pi / 180 / float64(c.Segments)
+	}
+	n := int(math.Round(length/c.SegmentLength)) + 1
+	if n < 2 {
+		n = 2
+	}
+	return n
+}
+
+// resample fits src into dst: averaged down if dst is smaller, linearly
+// interpolated up if it is bigger.
+func resample(dst, src []float64) {
+	if len(dst) <= len(src) {
+		downsample(dst, src)
+		return
+	}
+	last := len(src) - 1
+	for i := range dst {
+		x := float64(i) * float64(last) / float64(len(dst)-1)
+		j := int(x)
+		if j >= last {
+			dst[i] = src[last]
+			continue
+		}
+		dst[i] = src[j] + (src[j+1]-src[j])*(x-float64(j))
+	}
+}
+
+// downsample averages src into len(dst) evenly sized buckets.
+// if they are the same size it is just a copy.
+func downsample(dst, src []float64) {
+	if len(dst) == len(src) {
+		copy(dst, src)
+		return
+	}
+	for i := range dst {
+		from := i * len(src) / len(dst)
+		to := (i + 1) * len(src) / len(dst)
+		var sum float64
+		for _, x := range src[from:to] {
+			sum += x
+		}
+		dst[i] = sum / float64(to-from)
+	}
+}
+
+// reverse the order of xs, in place.
+func reverse(xs []float64) {
+	for i, j := 0, len(xs)-1; i < j; i, j = i+1, j-1 {
+		xs[i], xs[j] = xs[j], xs[i]
+	}
+}
+
+// withOpacity returns the color with its alpha scaled by the opacity.
+// the canvas blends non-opaque fills over what is already drawn.
+func withOpacity(c color.Color, opacity float64) color.Color {
+	if opacity >= 1 {
+		return c
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	n.A = uint8(float64(n.A) * math.Max(opacity, 0))
+	return n
+}
@@ -0,0 +1,279 @@
+package vis
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+// testConfig is the Config NewConfig makes from args, which needs no
+// audio (or ffmpeg)
+func testConfig(t *testing.T, args ...string) *Config {
+	t.Helper()
+	c, err := NewConfig(args...)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	return c
+}
+
+func TestSetTrack(t *testing.T) {
+	c, err := NewConfig("-bpm", "120", "-beat-pulse", "0.1", "-layout", layoutStereoSplit, "-layer", "-style waveform")
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	c.Stems = []Stem{{Name: "drums", File: "drums.wav", Color: color.RGBA{R: 255, A: 255}}}
+	vis, err := NewVisualisation(c)
+	if err != nil {
+		t.Fatalf("NewVisualisation: %v", err)
+	}
+	// the next track in a batch has its own tempo and rate
+	next := *c
+	next.BPM, next.BeatOffset, next.SampleRate = 90, 0.5, 48000
+	next.Layers = append([]Config(nil), c.Layers...)
+	next.Layers[0].SampleRate = 48000
+	vis.SetTrack(&next)
+	for name, v := range map[string]*Visualisation{"main": vis, "right": vis.right, "stem": vis.stems[0]} {
+		if v.tempo == nil || v.tempo.bpm != 90 || v.tempo.offset != 0.5 {
+			t.Errorf("the %s tempo is %+v, want 90bpm from 0.5s", name, v.tempo)
+		}
+		if v.sampleRate != 48000 {
+			t.Errorf("the %s sample rate is %d, want 48000", name, v.sampleRate)
+		}
+	}
+	// the layer has its own config, as it had when it was made
+	if layer := vis.layers[0]; layer.sampleRate != 48000 {
+		t.Errorf("the layer sample rate is %d, want 48000", layer.sampleRate)
+	}
+}
+
+func TestFinite(t *testing.T) {
+	for _, tc := range []struct{ x, want float64 }{
+		{1.5, 1.5},
+		{-2, -2},
+		{math.MaxFloat64, math.MaxFloat64},
+		{math.NaN(), 0},
+		{math.Inf(1), 0},
+		{math.Inf(-1), 0},
+	} {
+		if got := finite(tc.x); got != tc.want {
+			t.Errorf("finite(%g) = %g, want %g", tc.x, got, tc.want)
+		}
+	}
+}
+
+func TestSilenceAndClipping(t *testing.T) {
+	// silence, full scale, and whatever broken analysis might give
+	for name, level := range map[string]float64{
+		"silence": 0, "clipping": 1, "huge": math.MaxFloat64, "infinite": math.Inf(1), "nan": math.NaN(),
+	} {
+		for _, style := range []string{styleSpectrum, styleWaveform, styleSpectrogram} {
+			c := testConfig(t, "-style", style, "-width", "64", "-height", "64", "-peak-hold", "0.1", "-smoothing-kernel", "gaussian")
+			vis, err := NewVisualisation(c)
+			if err != nil {
+				t.Fatalf("NewVisualisation: %v", err)
+			}
+			af := &AudioFrame{sampleRate: c.SampleRate, gain: c.MagnitudeGain, data: make([]float64, 256), freq: make([]float64, 256)}
+			for i := range af.freq {
+				af.data[i], af.freq[i] = level, level
+			}
+			for f := 0; f < 3; f++ {
+				vis.CreateFrame(af)
+			}
+			for _, cache := range vis.cache {
+				if cache == nil {
+					continue
+				}
+				for i, x := range cache.raw {
+					if math.IsNaN(x) || math.IsInf(x, 0) {
+						t.Fatalf("%s %s: point %d is %g", name, style, i, x)
+					}
+				}
+			}
+			for i, p := range vis.peaks {
+				if math.IsNaN(p) || math.IsInf(p, 0) {
+					t.Fatalf("%s %s: peak %d is %g", name, style, i, p)
+				}
+			}
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	// a layer with its own right channel and stem, under a split with stems
+	c, err := NewConfig("-layout", layoutStereoSplit, "-layer", "-style waveform -layout "+layoutStereoSplit)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	c.Stems = []Stem{{Name: "drums", File: "drums.wav", Color: color.RGBA{R: 255, A: 255}}}
+	c.Layers[0].Stems = c.Stems
+	vis, err := NewVisualisation(c)
+	if err != nil {
+		t.Fatalf("NewVisualisation: %v", err)
+	}
+	vis.Seek(42)
+	var check func(name string, v *Visualisation)
+	check = func(name string, v *Visualisation) {
+		if v.frame != 42 {
+			t.Errorf("%s is at frame %d, want 42", name, v.frame)
+		}
+		if v.right != nil {
+			check(name+" right", v.right)
+		}
+		for _, stem := range v.stems {
+			check(name+" stem", stem)
+		}
+		for _, layer := range v.layers {
+			check(name+" layer", layer)
+		}
+	}
+	check("main", vis)
+	if len(vis.layers) != 1 || vis.layers[0].right == nil || len(vis.layers[0].stems) != 1 {
+		t.Fatal("the layer doesn't have its own right channel and stem to seek")
+	}
+}
+
+func TestPeaksHeldWithoutDrawing(t *testing.T) {
+	c, err := NewConfig("-peak-hold", "0.2", "-width", "64", "-height", "64")
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	drawn, err := NewVisualisation(c)
+	if err != nil {
+		t.Fatalf("NewVisualisation: %v", err)
+	}
+	skipped, err := NewVisualisation(c)
+	if err != nil {
+		t.Fatalf("NewVisualisation: %v", err)
+	}
+	af := &AudioFrame{sampleRate: c.SampleRate, gain: c.MagnitudeGain, freq: make([]float64, 512)}
+	for f := 0; f < 20; f++ {
+		// a loud band that moves, then silence for the peaks to fall in
+		for i := range af.freq {
+			af.freq[i] = 0
+		}
+		if f < 10 {
+			af.freq[10+f*5] = 50
+		}
+		drawn.CreateFrame(af)
+		if f%3 == 0 {
+			skipped.CreateFrame(af)
+		} else {
+			skipped.AddFrame(af)
+		}
+	}
+	held := false
+	for i := range drawn.peaks {
+		if drawn.peaks[i] != skipped.peaks[i] {
+			t.Fatalf("peak %d is %g drawing every frame, %g drawing some", i, drawn.peaks[i], skipped.peaks[i])
+		}
+		held = held || drawn.peaks[i] > 0
+	}
+	if !held {
+		t.Fatal("no peaks were held")
+	}
+}
+
+// smoothed is the points smoothed with the kernel of radius r
+func smoothed(kernel string, r int, points ...float64) []float64 {
+	cache := newVisCache(len(points))
+	copy(cache.raw, points)
+	(&Visualisation{}).doSmoothing(cache, kernel, r)
+	return cache.smoothed
+}
+
+func TestSmoothingKernels(t *testing.T) {
+	impulse := []float64{0, 0, 0, 0, 12, 0, 0, 0, 0}
+	g1, g2 := math.Exp(-0.5), math.Exp(-2)
+	for _, tc := range []struct {
+		kernel string
+		r      int
+		want   []float64
+	}{
+		{"box", 1, []float64{0, 0, 0, 4, 4, 4, 0, 0, 0}},
+		// 1, 2, 1
+		{"triangle", 1, []float64{0, 0, 0, 3, 6, 3, 0, 0, 0}},
+		// 1, 2, 3, 2, 1
+		{"triangle", 2, []float64{0, 0, 4.0 / 3, 8.0 / 3, 4, 8.0 / 3, 4.0 / 3, 0, 0}},
+		// sigma 1
+		{"gaussian", 2, []float64{0, 0,
+			12 * g2 / (1 + 2*g1 + 2*g2), 12 * g1 / (1 + 2*g1 + 2*g2), 12 / (1 + 2*g1 + 2*g2),
+			12 * g1 / (1 + 2*g1 + 2*g2), 12 * g2 / (1 + 2*g1 + 2*g2), 0, 0}},
+		// no smoothing
+		{"box", 0, impulse},
+		{"unknown", 2, impulse},
+	} {
+		got := smoothed(tc.kernel, tc.r, impulse...)
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("%s radius %d = %v, want %v", tc.kernel, tc.r, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSmoothingEdges(t *testing.T) {
+	// the weights off the end are left out, so a flat line stays flat
+	for kernel := range smoothingKernels {
+		for i, x := range smoothed(kernel, 3, 5, 5, 5, 5, 5) {
+			if math.Abs(x-5) > 1e-9 {
+				t.Errorf("%s smoothed point %d of a flat line to %g", kernel, i, x)
+			}
+		}
+	}
+}
+
+func TestAttackTime(t *testing.T) {
+	// the interpolated frames ease too, so the time is the same at any rate
+	for _, fpsOut := range []string{"30", "60"} {
+		c := testConfig(t, "-fps-out", fpsOut, "-attack", "0.2")
+		vis, err := NewVisualisation(c)
+		if err != nil {
+			t.Fatalf("NewVisualisation: %v", err)
+		}
+		instant := *c
+		instant.Attack = 0
+		once, err := NewVisualisation(&instant)
+		if err != nil {
+			t.Fatalf("NewVisualisation: %v", err)
+		}
+		quiet := &AudioFrame{sampleRate: c.SampleRate, gain: c.MagnitudeGain, data: make([]float64, 256), freq: make([]float64, 256)}
+		loud := &AudioFrame{sampleRate: c.SampleRate, gain: c.MagnitudeGain, data: make([]float64, 256), freq: make([]float64, 256)}
+		for i := range loud.freq {
+			loud.freq[i] = 1
+		}
+		once.AddFrame(loud)
+		want := once.cache[0].raw
+
+		vis.AddFrame(quiet)
+		frames := int(math.Round(c.Attack * OutputFrameRate(c)))
+		for f := 0; f < frames; f++ {
+			vis.AddFrame(loud)
+		}
+		for i, x := range vis.levels {
+			if got := x / want[i]; math.Abs(got-(1-1/math.E)) > 1e-9 {
+				t.Fatalf("-fps-out %s: band %d is %g of the way after %d frames, want 1-1/e", fpsOut, i, got, frames)
+			}
+		}
+	}
+}
+
+func TestLatestIsSmoothed(t *testing.T) {
+	c := testConfig(t, "-smoothing-kernel", "box")
+	vis, err := NewVisualisation(c)
+	if err != nil {
+		t.Fatalf("NewVisualisation: %v", err)
+	}
+	af := &AudioFrame{sampleRate: c.SampleRate, gain: c.MagnitudeGain, data: make([]float64, 256), freq: make([]float64, 256)}
+	af.freq[128] = 1
+	vis.AddFrame(af)
+	f, values := vis.Latest()
+	if f != 0 {
+		t.Errorf("the latest frame is %d, want 0", f)
+	}
+	// the spike is spread over its neighbours, as it's drawn
+	if values[128] >= 1 || values[127] == 0 || values[129] == 0 {
+		t.Errorf("the spike isn't smoothed: %g %g %g", values[127], values[128], values[129])
+	}
+}
@@ -0,0 +1,117 @@
+package vis
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+
+	// the formats we can load a watermark from
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// watermark positions
+const (
+	watermarkTopLeft     = "top-left"
+	watermarkTopRight    = "top-right"
+	watermarkBottomLeft  = "bottom-left"
+	watermarkBottomRight = "bottom-right"
+)
+
+// Watermark is a logo composited onto every frame.
+// It is scaled once when loaded, so drawing it is just a copy.
+type Watermark struct {
+	img  *image.RGBA
+	at   image.Point    // the top left corner on the frame
+	mask *image.Uniform // the opacity
+}
+
+// LoadWatermark loads the image in the config and works out where it goes
+func LoadWatermark(c *Config) (*Watermark, error) {
+	f, err := os.Open(c.Watermark)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding watermark %q: %w", c.Watermark, err)
+	}
+
+	// scale to the fraction of the frame width, keeping the aspect ratio
+	b := src.Bounds()
+	w := int(float64(c.Width) * c.WatermarkScale)
+	h := w * b.Dy() / b.Dx()
+	if w < 1 || h < 1 {
+		return nil, fmt.Errorf("watermark %q is too small to draw", c.Watermark)
+	}
+	img := scaleImage(src, w, h)
+
+	// a small margin from the edges
+	margin := c.Width / 50
+	var at image.Point
+	switch c.WatermarkPosition {
+	case watermarkTopLeft:
+		at = image.Pt(margin, margin)
+	case watermarkTopRight:
+		at = image.Pt(c.Width-margin-w, margin)
+	case watermarkBottomLeft:
+		at = image.Pt(margin, c.Height-margin-h)
+	case watermarkBottomRight:
+		at = image.Pt(c.Width-margin-w, c.Height-margin-h)
+	default:
+		return nil, fmt.Errorf("unknown watermark position: %q", c.WatermarkPosition)
+	}
+
+	return &Watermark{
+		img:  img,
+		at:   at,
+		mask: image.NewUniform(color.Alpha{uint8(c.WatermarkOpacity * 0xff)}),
+	}, nil
+}
+
+// Draw the watermark over the frame
+func (wm *Watermark) Draw(dst *image.RGBA) {
+	r := wm.img.Bounds().Add(wm.at)
+	draw.DrawMask(dst, r, wm.img, image.Point{}, wm.mask, image.Point{}, draw.Over)
+}
+
+// scaleImage resizes the image by averaging the source pixels that
+// fall into each destination pixel (or picking the nearest when enlarging).
+// It's not fast, but we only do it once.
+func scaleImage(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := span(b.Min.Y, b.Dy(), y, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := span(b.Min.X, b.Dx(), x, w)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+cr, g+cg, bl+cb, a+ca
+					n++
+				}
+			}
+			// these are premultiplied 16 bit, as is RGBA (but 8 bit)
+			dst.SetRGBA(x, y, color.RGBA{
+				uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// span is the range of source pixels for destination pixel i of n,
+// always at least one pixel wide.
+func span(start, size, i, n int) (int, int) {
+	from := start + i*size/n
+	to := start + (i+1)*size/n
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}